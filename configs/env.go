@@ -1,36 +1,417 @@
 package configs
 
 import (
-	"log"
+	"Project/functions"
+	"Project/jpegenc"
+	"Project/resample"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 var EnvConfigs *envConfigs
 
+// envConfigs holds every tunable of the service. Values come from, in
+// increasing priority: the defaults in defaultConfigs, app.env, the
+// per-environment file app.<APP_ENV>.{yaml,yml,toml,env}, the file named by
+// CONFIG_FILE, and finally environment variables of the same name. Fields
+// tagged redact are masked in the admin config dump.
 type envConfigs struct {
 	LocalServerPort string `mapstructure:"LOCAL_SERVER_PORT"`
-	SecretKey       string `mapstructure:"SECRET_KEY"`
-	GoogleCred      string `mapstructure:"GOOGLE_CRED"`
+	SecretKey       string `mapstructure:"SECRET_KEY" redact:"true"`
+
+	CredentialsSource string `mapstructure:"CREDENTIALS_SOURCE"` // auto, file, json, secret or default
+	GoogleCred        string `mapstructure:"GOOGLE_CRED"`        // service account key file path
+	GoogleCredJSON    string `mapstructure:"GOOGLE_CRED_JSON" redact:"true"`
+	GoogleCredSecret  string `mapstructure:"GOOGLE_CRED_SECRET"` // projects/*/secrets/*/versions/*
+
+	ProjectID  string `mapstructure:"PROJECT_ID"`
+	BucketName string `mapstructure:"BUCKET_NAME"`
+
+	SizePresets  string `mapstructure:"SIZE_PRESETS"`  // comma separated name=width, e.g. small=100
+	ResizeFilter string `mapstructure:"RESIZE_FILTER"` // nearest, linear, catmullrom or lanczos
+
+	JPEGQuality       int    `mapstructure:"JPEG_QUALITY"`
+	JPEGProgressive   bool   `mapstructure:"JPEG_PROGRESSIVE"`
+	JPEGSubsampling   string `mapstructure:"JPEG_SUBSAMPLING"`    // 420, 422 or 444
+	JPEGMaxBytes      int    `mapstructure:"JPEG_MAX_BYTES"`      // byte budget the quality is lowered to meet, 0 for none
+	JPEGPresetOptions string `mapstructure:"JPEG_PRESET_OPTIONS"` // per preset overrides, e.g. small:quality=70,progressive,max_bytes=15000;large:subsampling=444
+
+	ResizeFromVariants   bool    `mapstructure:"RESIZE_FROM_VARIANTS"`    // resize from a larger stored variant instead of the original
+	ResizeSourceMinScale float64 `mapstructure:"RESIZE_SOURCE_MIN_SCALE"` // how many times wider than the target that variant must be
+
+	WatermarkPath       string  `mapstructure:"WATERMARK_PATH"`
+	WatermarkOpacity    float64 `mapstructure:"WATERMARK_OPACITY"`
+	WatermarkWidthRatio float64 `mapstructure:"WATERMARK_WIDTH_RATIO"` // watermark width relative to the image width
+
+	MaxRequestBytes     int64  `mapstructure:"MAX_REQUEST_BYTES"`
+	MaxImageBytes       int64  `mapstructure:"MAX_IMAGE_BYTES"`
+	MaxImageWidth       int    `mapstructure:"MAX_IMAGE_WIDTH"`
+	MaxImageHeight      int    `mapstructure:"MAX_IMAGE_HEIGHT"`
+	MaxImagePixels      int64  `mapstructure:"MAX_IMAGE_PIXELS"`
+	AllowedImageFormats string `mapstructure:"ALLOWED_IMAGE_FORMATS"` // comma separated MIME types
+
+	WorkerCount  int    `mapstructure:"WORKER_COUNT"`
+	AutoVariants string `mapstructure:"AUTO_VARIANTS"` // comma separated variants generated after every upload, e.g. small,watermarked_large
+
+	BackgroundWorkers   int `mapstructure:"BACKGROUND_WORKERS"`    // goroutines generating auto variants and renditions
+	BackgroundQueueSize int `mapstructure:"BACKGROUND_QUEUE_SIZE"` // background jobs that may wait for a worker before new ones are dropped
+
+	RenditionFormats   string `mapstructure:"RENDITION_FORMATS"`    // avif and webp in order of preference, served to clients that accept them; empty for JPEG only
+	RenditionMaxPixels int64  `mapstructure:"RENDITION_MAX_PIXELS"` // variants larger than this are only served as JPEG; 0 for no limit
+
+	TracesExporter string `mapstructure:"TRACES_EXPORTER"` // otlp, stdout or none
+	LogLevel       string `mapstructure:"LOG_LEVEL"`       // debug, info, warn or error
+	LogFormat      string `mapstructure:"LOG_FORMAT"`      // json or text
+
+	StorageTimeout     time.Duration `mapstructure:"STORAGE_TIMEOUT"`
+	ReadinessTimeout   time.Duration `mapstructure:"READINESS_TIMEOUT"`
+	ShutdownTimeout    time.Duration `mapstructure:"SHUTDOWN_TIMEOUT"`
+	ShutdownDrainDelay time.Duration `mapstructure:"SHUTDOWN_DRAIN_DELAY"` // time /readyz reports draining before the listener closes
+}
+
+func defaultConfigs() *envConfigs {
+	return &envConfigs{
+		LocalServerPort:      "5000",
+		CredentialsSource:    "auto",
+		ProjectID:            "halogen-device-438608-v9",
+		BucketName:           "halogen-device-438608-v9.appspot.com",
+		SizePresets:          "small=100,medium=500,large=1500",
+		JPEGQuality:          90,
+		JPEGSubsampling:      "420",
+		ResizeFilter:         "lanczos",
+		ResizeFromVariants:   true,
+		ResizeSourceMinScale: 2,
+		WatermarkPath:        "Icares_Logo.png",
+		WatermarkOpacity:     0.7,
+		WatermarkWidthRatio:  0.2,
+		MaxRequestBytes:      32 << 20,
+		MaxImageBytes:        functions.DefaultLimits.MaxBytes,
+		MaxImageWidth:        functions.DefaultLimits.MaxWidth,
+		MaxImageHeight:       functions.DefaultLimits.MaxHeight,
+		MaxImagePixels:       functions.DefaultLimits.MaxPixels,
+		AllowedImageFormats:  strings.Join(functions.DefaultLimits.AllowedFormats, ","),
+		WorkerCount:          4,
+		BackgroundWorkers:    2,
+		BackgroundQueueSize:  100,
+		RenditionFormats:     "avif,webp",
+		RenditionMaxPixels:   4_000_000,
+		TracesExporter:       "none",
+		LogLevel:             "info",
+		LogFormat:            "json",
+		StorageTimeout:       60 * time.Second,
+		ReadinessTimeout:     3 * time.Second,
+		ShutdownTimeout:      30 * time.Second,
+	}
 }
 
 func InitiEnvConfigs() {
-	EnvConfigs = loadEnvVariables()
+	config, err := loadEnvVariables()
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	EnvConfigs = config
 }
-func loadEnvVariables() *envConfigs {
+
+func loadEnvVariables() (*envConfigs, error) {
 	viper.AddConfigPath(".")
 	viper.SetConfigName("app")
 	viper.SetConfigType("env")
 	if err := viper.ReadInConfig(); err != nil {
-		log.Print("Error reading env file", err)
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) {
+			return nil, fmt.Errorf("error reading app.env: %v", err)
+		}
+		slog.Info("app.env not found, using defaults and environment variables")
+	}
+
+	if appEnv := os.Getenv("APP_ENV"); appEnv != "" {
+		if err := mergeEnvironmentFile(appEnv); err != nil {
+			return nil, err
+		}
+	}
+	if configFile := os.Getenv("CONFIG_FILE"); configFile != "" {
+		if err := mergeConfigFile(configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	config := defaultConfigs()
+	// Bind every key so environment variables override file values
+	for _, key := range configKeys() {
+		if err := viper.BindEnv(key); err != nil {
+			return nil, err
+		}
+	}
+	if err := viper.Unmarshal(config); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// mergeEnvironmentFile merges app.<appEnv> with the first supported extension found.
+func mergeEnvironmentFile(appEnv string) error {
+	for _, ext := range []string{"yaml", "yml", "toml", "env"} {
+		path := fmt.Sprintf("app.%s.%s", appEnv, ext)
+		if _, err := os.Stat(path); err == nil {
+			return mergeConfigFile(path)
+		}
+	}
+	return fmt.Errorf("no configuration file found for APP_ENV=%s (looked for app.%s.{yaml,yml,toml,env})", appEnv, appEnv)
+}
+
+func mergeConfigFile(path string) error {
+	configType := strings.TrimPrefix(filepath.Ext(path), ".")
+	if configType == "yml" {
+		configType = "yaml"
+	}
+	viper.SetConfigFile(path)
+	viper.SetConfigType(configType)
+	if err := viper.MergeInConfig(); err != nil {
+		return fmt.Errorf("error reading config file %s: %v", path, err)
+	}
+	slog.Info("configuration file loaded", "path", path)
+	return nil
+}
+
+func configKeys() []string {
+	t := reflect.TypeOf(envConfigs{})
+	keys := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		keys = append(keys, t.Field(i).Tag.Get("mapstructure"))
+	}
+	return keys
+}
+
+// Validate reports every invalid setting at once.
+func (c *envConfigs) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
 	}
 
-	config := &envConfigs{
-		LocalServerPort: "5000",
+	port, err := strconv.Atoi(c.LocalServerPort)
+	check(err == nil && port > 0 && port < 65536, "LOCAL_SERVER_PORT must be a port number, got %q", c.LocalServerPort)
+	check(slices.Contains([]string{"auto", "file", "json", "secret", "default"}, c.CredentialsSource), "CREDENTIALS_SOURCE must be auto, file, json, secret or default, got %q", c.CredentialsSource)
+	check(c.CredentialsSource != "file" || c.GoogleCred != "", "GOOGLE_CRED is required when CREDENTIALS_SOURCE=file")
+	check(c.CredentialsSource != "json" || c.GoogleCredJSON != "", "GOOGLE_CRED_JSON is required when CREDENTIALS_SOURCE=json")
+	check(c.CredentialsSource != "secret" || c.GoogleCredSecret != "", "GOOGLE_CRED_SECRET is required when CREDENTIALS_SOURCE=secret")
+	check(c.ProjectID != "", "PROJECT_ID is required")
+	check(c.BucketName != "", "BUCKET_NAME is required")
+	if _, err := c.SizePresetWidths(); err != nil {
+		errs = append(errs, err)
 	}
+	if _, presets, err := c.JPEGOutputs(); err != nil {
+		errs = append(errs, err)
+	} else if widths, err := c.SizePresetWidths(); err == nil {
+		for name := range presets {
+			_, ok := widths[name]
+			check(ok || name == "original", "JPEG_PRESET_OPTIONS preset %q must be a size preset or original", name)
+		}
+	}
+	if _, err := resample.ParseFilter(c.ResizeFilter); err != nil {
+		errs = append(errs, fmt.Errorf("RESIZE_FILTER: %v", err))
+	}
+	check(c.ResizeSourceMinScale >= 1, "RESIZE_SOURCE_MIN_SCALE must be at least 1, got %v", c.ResizeSourceMinScale)
+	check(c.WatermarkPath != "", "WATERMARK_PATH is required")
+	check(c.WatermarkOpacity > 0 && c.WatermarkOpacity <= 1, "WATERMARK_OPACITY must be in (0, 1], got %v", c.WatermarkOpacity)
+	check(c.WatermarkWidthRatio > 0 && c.WatermarkWidthRatio <= 1, "WATERMARK_WIDTH_RATIO must be in (0, 1], got %v", c.WatermarkWidthRatio)
+	check(c.MaxRequestBytes > 0, "MAX_REQUEST_BYTES must be positive")
+	check(c.MaxImageBytes > 0, "MAX_IMAGE_BYTES must be positive")
+	check(c.MaxImageWidth > 0, "MAX_IMAGE_WIDTH must be positive")
+	check(c.MaxImageHeight > 0, "MAX_IMAGE_HEIGHT must be positive")
+	check(c.MaxImagePixels > 0, "MAX_IMAGE_PIXELS must be positive")
+	for _, format := range c.AllowedFormats() {
+		check(strings.HasPrefix(format, "image/"), "ALLOWED_IMAGE_FORMATS entries must be image MIME types, got %q", format)
+	}
+	check(c.WorkerCount > 0, "WORKER_COUNT must be positive")
+	check(c.BackgroundWorkers > 0, "BACKGROUND_WORKERS must be positive")
+	check(c.BackgroundQueueSize >= 0, "BACKGROUND_QUEUE_SIZE must not be negative, got %d", c.BackgroundQueueSize)
+	check(len(c.AutoVariantList()) <= functions.MaxBatchVariants, "AUTO_VARIANTS must list at most %d variants", functions.MaxBatchVariants)
+	if presets, err := c.SizePresetWidths(); err == nil {
+		for _, variant := range c.AutoVariantList() {
+			_, _, err := functions.ParseVariantIn(variant, presets)
+			check(err == nil, "AUTO_VARIANTS entry %q must be a size preset, optionally prefixed with watermarked_, or watermarked_original", variant)
+		}
+	}
+	for _, format := range c.RenditionFormatList() {
+		check(slices.Contains([]string{"avif", "webp"}, format), "RENDITION_FORMATS entries must be avif or webp, got %q", format)
+	}
+	check(c.RenditionMaxPixels >= 0, "RENDITION_MAX_PIXELS must not be negative, got %d", c.RenditionMaxPixels)
+	check(slices.Contains([]string{"none", "otlp", "stdout"}, c.TracesExporter), "TRACES_EXPORTER must be none, otlp or stdout, got %q", c.TracesExporter)
+	check(slices.Contains([]string{"debug", "info", "warn", "error"}, strings.ToLower(c.LogLevel)), "LOG_LEVEL must be debug, info, warn or error, got %q", c.LogLevel)
+	check(slices.Contains([]string{"json", "text"}, strings.ToLower(c.LogFormat)), "LOG_FORMAT must be json or text, got %q", c.LogFormat)
+	check(c.StorageTimeout > 0, "STORAGE_TIMEOUT must be positive")
+	check(c.ReadinessTimeout > 0, "READINESS_TIMEOUT must be positive")
+	check(c.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT must be positive")
+	check(c.ShutdownDrainDelay >= 0, "SHUTDOWN_DRAIN_DELAY must not be negative")
+
+	return errors.Join(errs...)
+}
+
+// SizePresetWidths parses SIZE_PRESETS into preset name to width in pixels.
+func (c *envConfigs) SizePresetWidths() (map[string]int, error) {
+	presets := map[string]int{}
+	for _, entry := range strings.Split(c.SizePresets, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		width, err := strconv.Atoi(value)
+		if !ok || name == "" || err != nil || width <= 0 {
+			return nil, fmt.Errorf("SIZE_PRESETS entry %q must look like name=width", entry)
+		}
+		presets[strings.ToLower(name)] = width
+	}
+	return presets, nil
+}
+
+// JPEGOutput is the resolved JPEG encoding of one output.
+type JPEGOutput struct {
+	jpegenc.Options
+	MaxBytes int
+}
+
+// JPEGOutputs resolves the default JPEG encoding from the JPEG_* settings and
+// the per preset encodings of JPEG_PRESET_OPTIONS, which start from the
+// defaults and override only the options they name.
+func (c *envConfigs) JPEGOutputs() (JPEGOutput, map[string]JPEGOutput, error) {
+	defaults := JPEGOutput{
+		Options:  jpegenc.Options{Quality: c.JPEGQuality, Progressive: c.JPEGProgressive},
+		MaxBytes: c.JPEGMaxBytes,
+	}
+	var err error
+	if defaults.Subsampling, err = jpegenc.ParseSubsampling(c.JPEGSubsampling); err != nil {
+		return JPEGOutput{}, nil, fmt.Errorf("JPEG_SUBSAMPLING: %v", err)
+	}
+	if c.JPEGQuality < 1 || c.JPEGQuality > 100 {
+		return JPEGOutput{}, nil, fmt.Errorf("JPEG_QUALITY must be between 1 and 100, got %d", c.JPEGQuality)
+	}
+	if c.JPEGMaxBytes < 0 {
+		return JPEGOutput{}, nil, fmt.Errorf("JPEG_MAX_BYTES must not be negative, got %d", c.JPEGMaxBytes)
+	}
+
+	presets := map[string]JPEGOutput{}
+	for _, entry := range strings.Split(c.JPEGPresetOptions, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, options, ok := strings.Cut(entry, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || name == "" {
+			return JPEGOutput{}, nil, fmt.Errorf("JPEG_PRESET_OPTIONS entry %q must look like preset:option,option", entry)
+		}
+		output := defaults
+		for _, option := range strings.Split(options, ",") {
+			if err := output.set(strings.TrimSpace(option)); err != nil {
+				return JPEGOutput{}, nil, fmt.Errorf("JPEG_PRESET_OPTIONS preset %q: %v", name, err)
+			}
+		}
+		if err := output.check(); err != nil {
+			return JPEGOutput{}, nil, fmt.Errorf("JPEG_PRESET_OPTIONS preset %q: %v", name, err)
+		}
+		presets[name] = output
+	}
+	return defaults, presets, nil
+}
+
+// set applies one option: quality=N, max_bytes=N, subsampling=420|422|444,
+// progressive or baseline.
+func (o *JPEGOutput) set(option string) error {
+	key, value, _ := strings.Cut(option, "=")
+	var err error
+	switch strings.ToLower(key) {
+	case "":
+		return nil
+	case "progressive":
+		o.Progressive = true
+	case "baseline":
+		o.Progressive = false
+	case "quality":
+		o.Quality, err = strconv.Atoi(value)
+	case "max_bytes":
+		o.MaxBytes, err = strconv.Atoi(value)
+	case "subsampling":
+		o.Subsampling, err = jpegenc.ParseSubsampling(value)
+	default:
+		return fmt.Errorf("unknown option %q, expected quality, max_bytes, subsampling, progressive or baseline", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return nil
+}
+
+func (o JPEGOutput) check() error {
+	if o.Quality < 1 || o.Quality > 100 {
+		return fmt.Errorf("quality must be between 1 and 100, got %d", o.Quality)
+	}
+	if o.MaxBytes < 0 {
+		return fmt.Errorf("max_bytes must not be negative, got %d", o.MaxBytes)
+	}
+	return nil
+}
+
+// AllowedFormats splits ALLOWED_IMAGE_FORMATS.
+func (c *envConfigs) AllowedFormats() []string {
+	var formats []string
+	for _, format := range strings.Split(c.AllowedImageFormats, ",") {
+		if format = strings.TrimSpace(format); format != "" {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// AutoVariantList splits AUTO_VARIANTS.
+func (c *envConfigs) AutoVariantList() []string {
+	var variants []string
+	for _, variant := range strings.Split(c.AutoVariants, ",") {
+		if variant = strings.ToLower(strings.TrimSpace(variant)); variant != "" {
+			variants = append(variants, variant)
+		}
+	}
+	return variants
+}
+
+// RenditionFormatList splits RENDITION_FORMATS.
+func (c *envConfigs) RenditionFormatList() []string {
+	var formats []string
+	for _, format := range strings.Split(c.RenditionFormats, ",") {
+		if format = strings.ToLower(strings.TrimSpace(format)); format != "" {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
 
-	if err := viper.Unmarshal(&config); err != nil {
-		log.Fatal(err)
+// Redacted returns the configuration keyed by setting name, with secrets masked.
+func (c *envConfigs) Redacted() map[string]interface{} {
+	v := reflect.ValueOf(*c)
+	t := v.Type()
+	dump := make(map[string]interface{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i).Interface()
+		if field.Tag.Get("redact") == "true" && !v.Field(i).IsZero() {
+			value = "REDACTED"
+		}
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		dump[field.Tag.Get("mapstructure")] = value
 	}
-	return config
+	return dump
 }
@@ -0,0 +1,47 @@
+package configs
+
+import (
+	"Project/functions"
+	"strings"
+	"testing"
+)
+
+// TestValidateAutoVariants checks that AUTO_VARIANTS accepts exactly the
+// variants functions.ParseVariant accepts at runtime.
+func TestValidateAutoVariants(t *testing.T) {
+	tests := []struct {
+		variant string
+		valid   bool
+	}{
+		{"small", true},
+		{"LARGE", true},
+		{"watermarked_medium", true},
+		{"watermarked_original", true},
+		{"original", false},
+		{"huge", false},
+		{"watermarked_huge", false},
+		{"watermarked_", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.variant, func(t *testing.T) {
+			config := defaultConfigs()
+			config.AutoVariants = tt.variant
+			err := config.Validate()
+			if got := err == nil; got != tt.valid {
+				t.Errorf("Validate: got %v, want valid %v", err, tt.valid)
+			}
+			if err != nil && !strings.Contains(err.Error(), "AUTO_VARIANTS") {
+				t.Errorf("Validate: got %v, want an AUTO_VARIANTS error", err)
+			}
+
+			presets, err := config.SizePresetWidths()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _, err = functions.ParseVariantIn(strings.ToLower(tt.variant), presets)
+			if got := err == nil; got != tt.valid {
+				t.Errorf("ParseVariantIn: got %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package functions
+
+import (
+	"context"
+	"slices"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+)
+
+// PlanAutoVariants resolves the variants to generate after an upload. A nil
+// list means Settings.AutoVariants; an empty list generates nothing. Call it
+// before storing the upload so a bad variant name or too long a list fails
+// the request early.
+func PlanAutoVariants(variants []string) ([]string, error) {
+	if variants == nil {
+		variants = Settings.AutoVariants
+	}
+	pending := make([]string, 0, len(variants))
+	for _, variant := range variants {
+		if _, _, err := ParseVariant(variant); err != nil {
+			return nil, err
+		}
+		if !slices.Contains(pending, variant) {
+			pending = append(pending, variant)
+		}
+	}
+	if len(pending) > MaxBatchVariants {
+		return nil, newError(ErrInvalidInput, nil, "at most %d variants can be generated after an upload", MaxBatchVariants)
+	}
+	return pending, nil
+}
+
+// GenerateVariantsInBackground queues the planned variants of an uploaded
+// image for creation without waiting for them. They show up in
+// GetImageSummary as they are stored; failures are logged and counted. It
+// returns false when the background queue is full, in which case nothing is
+// generated.
+func GenerateVariantsInBackground(ctx context.Context, imageID string, pending []string, storageClient *storage.Client, firestoreClient *firestore.Client) bool {
+	if len(pending) == 0 {
+		return true
+	}
+	return runInBackground(ctx, "variants", func(ctx context.Context) error {
+		_, err := createVariants(ctx, imageID, pending, storageClient, firestoreClient)
+		return err
+	}, "image_id", imageID, "variants", pending)
+}
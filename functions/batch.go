@@ -0,0 +1,165 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"golang.org/x/sync/errgroup"
+)
+
+// MaxBatchVariants bounds the variants a single batch can ask for.
+const MaxBatchVariants = 20
+
+// variantOutputs records which variants of one size a batch needs.
+type variantOutputs struct{ plain, watermarked bool }
+
+// planVariants groups variants by size, so each size is resized once. The
+// error names the offending item for an empty or oversized list, an unknown
+// variant or one listed twice.
+func planVariants(variants []string) (map[string]*variantOutputs, error) {
+	if len(variants) == 0 || len(variants) > MaxBatchVariants {
+		return nil, newError(ErrInvalidInput, nil, "between 1 and %d variants are required", MaxBatchVariants)
+	}
+	sizes := map[string]*variantOutputs{}
+	for i, variant := range variants {
+		sizename, watermarked, err := ParseVariant(variant)
+		if err != nil {
+			return nil, itemError(fmt.Sprintf("variants[%d]", i), err)
+		}
+		out := sizes[sizename]
+		if out == nil {
+			out = &variantOutputs{}
+			sizes[sizename] = out
+		}
+		listed := &out.plain
+		if watermarked {
+			listed = &out.watermarked
+		}
+		if *listed {
+			return nil, newError(ErrInvalidInput, nil, "variants[%d]: %s is listed more than once", i, variant)
+		}
+		*listed = true
+	}
+	return sizes, nil
+}
+
+// itemError prefixes err with the batch item it belongs to, keeping its kind
+// so the item is named in the response as well as in the logs.
+func itemError(item string, err error) error {
+	var domainErr *Error
+	if !errors.As(err, &domainErr) {
+		return fmt.Errorf("%s: %w", item, err)
+	}
+	return &Error{Kind: domainErr.Kind, Detail: item + ": " + domainErr.Detail, Err: domainErr.Err}
+}
+
+// CreateVariants produces several variants, such as "small" and
+// "watermarked_large", from a single download and decode of the original, or
+// of a larger stored variant when Settings.ResizeSource allows it.
+// Each size is resized once and shared by its plain and watermarked
+// variants. Sizes are processed concurrently by at most Settings.WorkerCount
+// goroutines; the first failure cancels the rest and is reported with the
+// variant it belongs to.
+func CreateVariants(ctx context.Context, imageID string, variants []string, storageClient *storage.Client, firestoreClient *firestore.Client) ([]VariantSummary, error) {
+	defer beginJob()()
+	return createVariants(ctx, imageID, variants, storageClient, firestoreClient)
+}
+
+// createVariants is CreateVariants for callers that registered the job
+// themselves.
+func createVariants(ctx context.Context, imageID string, variants []string, storageClient *storage.Client, firestoreClient *firestore.Client) (summaries []VariantSummary, err error) {
+	ctx, span := startImageSpan(ctx, "CreateVariants", imageID, "batch")
+	defer func() { endSpan(span, err) }()
+	start := time.Now()
+
+	sizes, err := planVariants(variants)
+	if err != nil {
+		return nil, err
+	}
+
+	// One source serves every size, so it must be large enough for the
+	// widest; the original itself needs the original
+	var img image.Image
+	widest, names := 0, make([]string, 0, len(sizes))
+	for sizename := range sizes {
+		widest = max(widest, Settings.SizePresets[strings.ToLower(sizename)])
+		names = append(names, sizename)
+	}
+	if sizes[OriginalSize] != nil {
+		img, err = downloadOriginal(ctx, storageClient, imageID)
+	} else {
+		img, _, err = resizeSource(ctx, storageClient, firestoreClient, imageID, widest, names...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var watermark image.Image
+	for _, out := range sizes {
+		if out.watermarked {
+			if watermark, err = loadWatermark(); err != nil {
+				return nil, fmt.Errorf("watermark loading failed: %v", err)
+			}
+			break
+		}
+	}
+
+	results := make(chan VariantSummary, len(variants))
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(max(Settings.WorkerCount, 1))
+	for sizename, out := range sizes {
+		group.Go(func() error {
+			resized := img
+			if sizename != OriginalSize {
+				resizeFunc, err := resizeFuncForSize(sizename)
+				if err != nil {
+					return itemError(sizename, err)
+				}
+				_, stage := startStage(groupCtx, "resize")
+				resized = resizeFunc(img)
+				stage.end(nil)
+
+				// A watermarked variant is recorded with its resized source,
+				// as when it is created on its own
+				path, err := storeResized(groupCtx, storageClient, firestoreClient, imageID, sizename, resized)
+				if err != nil {
+					return itemError(sizename, err)
+				}
+				if out.plain {
+					results <- VariantSummary{Name: sizename, Path: path}
+				}
+			}
+			if !out.watermarked {
+				return nil
+			}
+
+			_, stage := startStage(groupCtx, "watermark")
+			watermarked := AddWatermark(resized, watermark)
+			stage.end(nil)
+			path, err := storeWatermarked(groupCtx, storageClient, firestoreClient, imageID, sizename, watermarked)
+			if err != nil {
+				return itemError(VariantName(sizename, true), err)
+			}
+			results <- VariantSummary{Name: VariantName(sizename, true), Path: path}
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	close(results)
+
+	for summary := range results {
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	slog.InfoContext(ctx, "variants saved", "image_id", imageID, "variants", len(summaries), "duration", time.Since(start))
+	return summaries, nil
+}
@@ -0,0 +1,121 @@
+package functions
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanVariants(t *testing.T) {
+	tooMany := make([]string, MaxBatchVariants+1)
+	for i := range tooMany {
+		tooMany[i] = "small"
+	}
+	tests := []struct {
+		name     string
+		variants []string
+		want     map[string]*variantOutputs
+		wantErr  string
+	}{
+		{"one size", []string{"small"}, map[string]*variantOutputs{"small": {plain: true}}, ""},
+		{
+			"plain and watermarked share a size",
+			[]string{"watermarked_medium", "small", "medium"},
+			map[string]*variantOutputs{"small": {plain: true}, "medium": {plain: true, watermarked: true}},
+			"",
+		},
+		{"watermarked original", []string{"watermarked_original"}, map[string]*variantOutputs{"original": {watermarked: true}}, ""},
+		{"nil list", nil, nil, "between 1 and 20 variants are required"},
+		{"empty list", []string{}, nil, "between 1 and 20 variants are required"},
+		{"too many items", tooMany, nil, "between 1 and 20 variants are required"},
+		{"unknown variant", []string{"small", "huge"}, nil, "variants[1]: invalid variant: huge"},
+		{"plain original", []string{"original"}, nil, "variants[0]: invalid variant: original"},
+		{"duplicate", []string{"small", "large", "small"}, nil, "variants[2]: small is listed more than once"},
+		{"duplicate watermarked", []string{"watermarked_large", "large", "watermarked_large"}, nil, "variants[2]: watermarked_large is listed more than once"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := planVariants(tt.variants)
+			if tt.wantErr != "" {
+				var domainErr *Error
+				if !errors.Is(err, ErrInvalidInput) || !errors.As(err, &domainErr) || domainErr.Detail != tt.wantErr {
+					t.Fatalf("got %v, %v; want invalid input %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, %v; want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestItemError(t *testing.T) {
+	cause := errors.New("connection reset")
+	tests := []struct {
+		name       string
+		err        error
+		kind       error
+		wantDetail string
+		wantError  string
+	}{
+		{
+			"domain error keeps its kind",
+			backendError(cause, "failed to save image"),
+			ErrUpstream,
+			"watermarked_large: failed to save image",
+			"watermarked_large: failed to save image: connection reset",
+		},
+		{
+			"kind without a cause",
+			newError(ErrNotFound, nil, "image not found"),
+			ErrNotFound,
+			"watermarked_large: image not found",
+			"watermarked_large: image not found",
+		},
+		{"plain error stays internal", cause, nil, "", "watermarked_large: connection reset"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := itemError("watermarked_large", tt.err)
+			if err.Error() != tt.wantError {
+				t.Errorf("Error() = %q, want %q", err.Error(), tt.wantError)
+			}
+			if tt.kind != nil && !errors.Is(err, tt.kind) {
+				t.Errorf("got %v, want kind %v", err, tt.kind)
+			}
+			var domainErr *Error
+			if got := errors.As(err, &domainErr); got != (tt.wantDetail != "") || got && domainErr.Detail != tt.wantDetail {
+				t.Errorf("got detail %v, want %q", domainErr, tt.wantDetail)
+			}
+			if tt.kind == nil && ErrorType(err) != "internal" {
+				t.Errorf("got type %s, want internal", ErrorType(err))
+			}
+		})
+	}
+}
+
+func TestPlanAutoVariantsLimit(t *testing.T) {
+	defer func(presets map[string]int) { Settings.SizePresets = presets }(Settings.SizePresets)
+	Settings.SizePresets = map[string]int{}
+	var variants []string
+	for i := range MaxBatchVariants / 2 {
+		name := fmt.Sprintf("s%d", i)
+		Settings.SizePresets[name] = 100 + i
+		variants = append(variants, name, "watermarked_"+name)
+	}
+
+	// Repeats are dropped before the list is counted
+	pending, err := PlanAutoVariants(append(variants, variants...))
+	if err != nil || len(pending) != MaxBatchVariants {
+		t.Fatalf("got %v, %v; want %d variants", pending, err, MaxBatchVariants)
+	}
+
+	Settings.SizePresets["extra"] = 2000
+	_, err = PlanAutoVariants(append(variants, "extra"))
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "at most 20") {
+		t.Fatalf("got %v; want an invalid input error naming the limit", err)
+	}
+}
@@ -0,0 +1,233 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"github.com/disintegration/imaging"
+)
+
+// CropSpec describes a crop either as an explicit rectangle or as an aspect
+// ratio positioned by a gravity.
+type CropSpec struct {
+	// Explicit rectangle, in pixels or, when Unit is "percent", in percent of
+	// the image dimensions.
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	Unit   string  `json:"unit"`
+
+	// Aspect ratio such as "1:1", "16:9" or "4:5". The largest region with
+	// this ratio is cut from the image, anchored by Gravity, which is a
+	// compass direction, "center" or "smart".
+	Aspect  string `json:"aspect"`
+	Gravity string `json:"gravity"`
+
+	// Focal is the image's focal point. Aspect crops always keep it in
+	// frame, and it is the default gravity when set.
+	Focal *FocalPoint `json:"-"`
+}
+
+// gravityAnchors maps a gravity to the horizontal and vertical position of
+// the crop within the free space, from 0 (left/top) to 1 (right/bottom).
+var gravityAnchors = map[string][2]float64{
+	"center":    {0.5, 0.5},
+	"north":     {0.5, 0},
+	"south":     {0.5, 1},
+	"east":      {1, 0.5},
+	"west":      {0, 0.5},
+	"northeast": {1, 0},
+	"northwest": {0, 0},
+	"southeast": {1, 1},
+	"southwest": {0, 1},
+}
+
+// Rectangle resolves the spec against the image.
+func (s CropSpec) Rectangle(img image.Image) (image.Rectangle, error) {
+	bounds := img.Bounds()
+	if s.Aspect != "" {
+		return s.aspectRectangle(img)
+	}
+
+	x, y, w, h := s.X, s.Y, s.Width, s.Height
+	switch s.Unit {
+	case "", "px":
+	case "percent":
+		x = x * float64(bounds.Dx()) / 100
+		w = w * float64(bounds.Dx()) / 100
+		y = y * float64(bounds.Dy()) / 100
+		h = h * float64(bounds.Dy()) / 100
+	default:
+		return image.Rectangle{}, newError(ErrInvalidInput, nil, "unit must be px or percent, got %q", s.Unit)
+	}
+	// image.Rect would swap the corners of a negative size instead of failing
+	if w <= 0 || h <= 0 {
+		return image.Rectangle{}, newError(ErrInvalidInput, nil, "crop needs either an aspect or a positive width and height")
+	}
+	rect := image.Rect(int(x), int(y), int(x+w), int(y+h)).Add(bounds.Min)
+	if rect.Empty() {
+		return image.Rectangle{}, newError(ErrInvalidInput, nil, "crop needs either an aspect or a positive width and height")
+	}
+	if !rect.In(bounds) {
+		return image.Rectangle{}, newError(ErrInvalidInput, nil, "crop %v is outside the %dx%d image", rect, bounds.Dx(), bounds.Dy())
+	}
+	return rect, nil
+}
+
+func (s CropSpec) aspectRectangle(img image.Image) (image.Rectangle, error) {
+	ratioW, ratioH, err := parseAspect(s.Aspect)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	gravity, err := s.gravity()
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	cropW, cropH := w, w*ratioH/ratioW
+	if cropH > h {
+		cropW, cropH = h*ratioW/ratioH, h
+	}
+	if cropW <= 0 || cropH <= 0 {
+		return image.Rectangle{}, newError(ErrInvalidInput, nil, "aspect %s does not fit a %dx%d image", s.Aspect, w, h)
+	}
+	var x, y int
+	switch gravity {
+	case GravitySmart:
+		rect := smartCropRect(img, cropW, cropH).Sub(bounds.Min)
+		x, y = rect.Min.X, rect.Min.Y
+	case GravityFocal:
+		x = focalPixel(s.Focal.X, w) - cropW/2
+		y = focalPixel(s.Focal.Y, h) - cropH/2
+	default:
+		anchor := gravityAnchors[gravity]
+		x = int(float64(w-cropW) * anchor[0])
+		y = int(float64(h-cropH) * anchor[1])
+	}
+	if s.Focal != nil {
+		x = keepInFrame(x, cropW, focalPixel(s.Focal.X, w))
+		y = keepInFrame(y, cropH, focalPixel(s.Focal.Y, h))
+	}
+	x = min(max(x, 0), w-cropW)
+	y = min(max(y, 0), h-cropH)
+	return image.Rect(x, y, x+cropW, y+cropH).Add(bounds.Min), nil
+}
+
+// gravity returns the normalized gravity, defaulting to the focal point when
+// the image has one and to the center otherwise.
+func (s CropSpec) gravity() (string, error) {
+	gravity := strings.ToLower(s.Gravity)
+	switch {
+	case gravity == "" && s.Focal != nil:
+		return GravityFocal, nil
+	case gravity == "":
+		return "center", nil
+	case gravity == GravityFocal && s.Focal == nil:
+		return "", newError(ErrInvalidInput, nil, "gravity focal needs a focal point set on the image")
+	case gravity == GravitySmart || gravity == GravityFocal:
+		return gravity, nil
+	}
+	if _, ok := gravityAnchors[gravity]; !ok {
+		return "", newError(ErrInvalidInput, nil, "unknown gravity %q", s.Gravity)
+	}
+	return gravity, nil
+}
+
+// focalPixel returns the pixel a normalized focal coordinate falls on; 1 is
+// the last pixel rather than one past it.
+func focalPixel(v float64, length int) int {
+	return min(int(v*float64(length)), length-1)
+}
+
+// keepInFrame moves a window starting at start with the given length the
+// least amount needed for it to contain point.
+func keepInFrame(start, length, point int) int {
+	if point < start {
+		return point
+	}
+	if point >= start+length {
+		return point - length + 1
+	}
+	return start
+}
+
+// derivativeID names the derivative a spec produces. Aspect crops are named
+// after the spec rather than the resulting rectangle, so regenerating one
+// after the focal point moves replaces it in place.
+func (s CropSpec) derivativeID(rect image.Rectangle) string {
+	if s.Aspect != "" {
+		ratioW, ratioH, _ := parseAspect(s.Aspect)
+		gravity := strings.ToLower(s.Gravity)
+		if gravity == "" {
+			gravity = "default"
+		}
+		return fmt.Sprintf("crop_%dx%d_%s", ratioW, ratioH, gravity)
+	}
+	return fmt.Sprintf("crop_%d_%d_%dx%d", rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+}
+
+// maxAspectTerm bounds each side of an aspect ratio, so scaling the image
+// dimensions by it cannot overflow.
+const maxAspectTerm = 10000
+
+func parseAspect(aspect string) (int, int, error) {
+	wText, hText, ok := strings.Cut(aspect, ":")
+	w, errW := strconv.Atoi(wText)
+	h, errH := strconv.Atoi(hText)
+	if !ok || errW != nil || errH != nil || w <= 0 || h <= 0 {
+		return 0, 0, newError(ErrInvalidInput, nil, "aspect must look like 16:9, got %q", aspect)
+	}
+	if w > maxAspectTerm || h > maxAspectTerm {
+		return 0, 0, newError(ErrInvalidInput, nil, "aspect terms must be at most %d, got %q", maxAspectTerm, aspect)
+	}
+	return w, h, nil
+}
+
+// CropImage crops the original image and stores the result as a derivative.
+func CropImage(ctx context.Context, imageID string, spec CropSpec, storageClient *storage.Client, firestoreClient *firestore.Client) (derivative *DerivativeSummary, err error) {
+	ctx, span := startImageSpan(ctx, "CropImage", imageID, "crop")
+	defer func() { endSpan(span, err) }()
+	defer beginJob()()
+
+	if spec.Focal, err = GetFocalPoint(ctx, firestoreClient, imageID); err != nil {
+		return nil, err
+	}
+	img, err := downloadOriginal(ctx, storageClient, imageID)
+	if err != nil {
+		return nil, err
+	}
+	return cropDerivative(ctx, imageID, img, spec, storageClient, firestoreClient)
+}
+
+func cropDerivative(ctx context.Context, imageID string, img image.Image, spec CropSpec, storageClient *storage.Client, firestoreClient *firestore.Client) (*DerivativeSummary, error) {
+	rect, err := spec.Rectangle(img)
+	if err != nil {
+		return nil, err
+	}
+
+	_, stage := startStage(ctx, "crop")
+	cropped := imaging.Crop(img, rect)
+	stage.end(nil)
+
+	rel := rect.Sub(img.Bounds().Min)
+	return storeDerivative(ctx, storageClient, firestoreClient, imageID, DerivativeSummary{
+		ID:   spec.derivativeID(rel),
+		Kind: "crop",
+		Params: map[string]interface{}{
+			"x":       rel.Min.X,
+			"y":       rel.Min.Y,
+			"width":   rel.Dx(),
+			"height":  rel.Dy(),
+			"aspect":  spec.Aspect,
+			"gravity": spec.Gravity,
+		},
+	}, cropped)
+}
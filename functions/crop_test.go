@@ -0,0 +1,112 @@
+package functions
+
+import (
+	"errors"
+	"image"
+	"testing"
+)
+
+func TestCropRectangle(t *testing.T) {
+	img := image.NewRGBA(image.Rect(10, 20, 210, 120))
+	tests := []struct {
+		name    string
+		spec    CropSpec
+		want    image.Rectangle
+		wantErr bool
+	}{
+		{"pixels relative to the origin", CropSpec{X: 100, Y: 10, Width: 50, Height: 40}, image.Rect(110, 30, 160, 70), false},
+		{"percent", CropSpec{X: 50, Y: 50, Width: 50, Height: 50, Unit: "percent"}, image.Rect(110, 70, 210, 120), false},
+		{"negative width", CropSpec{X: 100, Y: 10, Width: -50, Height: 40}, image.Rectangle{}, true},
+		{"negative height", CropSpec{X: 100, Y: 50, Width: 50, Height: -40}, image.Rectangle{}, true},
+		{"zero size", CropSpec{X: 100, Y: 10}, image.Rectangle{}, true},
+		{"outside the image", CropSpec{X: 180, Y: 10, Width: 50, Height: 40}, image.Rectangle{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.spec.Rectangle(img)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidInput) {
+					t.Fatalf("got %v, %v; want an invalid input error", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("got %v, %v; want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAspectCropGravity(t *testing.T) {
+	// 200x100, not at the origin
+	img := image.NewRGBA(image.Rect(10, 20, 210, 120))
+	tests := []struct {
+		aspect  string
+		gravity string
+		want    image.Rectangle
+	}{
+		// 1:1 leaves horizontal room only
+		{"1:1", "", image.Rect(60, 20, 160, 120)},
+		{"1:1", "center", image.Rect(60, 20, 160, 120)},
+		{"1:1", "north", image.Rect(60, 20, 160, 120)},
+		{"1:1", "south", image.Rect(60, 20, 160, 120)},
+		{"1:1", "east", image.Rect(110, 20, 210, 120)},
+		{"1:1", "west", image.Rect(10, 20, 110, 120)},
+		{"1:1", "northeast", image.Rect(110, 20, 210, 120)},
+		{"1:1", "northwest", image.Rect(10, 20, 110, 120)},
+		{"1:1", "southeast", image.Rect(110, 20, 210, 120)},
+		{"1:1", "SouthWest", image.Rect(10, 20, 110, 120)},
+		// 4:1 leaves vertical room only
+		{"4:1", "center", image.Rect(10, 45, 210, 95)},
+		{"4:1", "north", image.Rect(10, 20, 210, 70)},
+		{"4:1", "south", image.Rect(10, 70, 210, 120)},
+		{"4:1", "east", image.Rect(10, 45, 210, 95)},
+		{"4:1", "west", image.Rect(10, 45, 210, 95)},
+		{"4:1", "northeast", image.Rect(10, 20, 210, 70)},
+		{"4:1", "northwest", image.Rect(10, 20, 210, 70)},
+		{"4:1", "southeast", image.Rect(10, 70, 210, 120)},
+		{"4:1", "southwest", image.Rect(10, 70, 210, 120)},
+		// The image's own ratio keeps all of it
+		{"2:1", "northwest", image.Rect(10, 20, 210, 120)},
+		{"10000:5000", "center", image.Rect(10, 20, 210, 120)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.aspect+" "+tt.gravity, func(t *testing.T) {
+			got, err := CropSpec{Aspect: tt.aspect, Gravity: tt.gravity}.Rectangle(img)
+			if err != nil || got != tt.want {
+				t.Fatalf("got %v, %v; want %v", got, err, tt.want)
+			}
+		})
+	}
+
+	got, err := CropSpec{Aspect: "1:1", Gravity: "smart"}.Rectangle(img)
+	if err != nil || got.Dx() != 100 || got.Dy() != 100 || !got.In(img.Bounds()) {
+		t.Errorf("smart: got %v, %v; want a 100x100 square inside %v", got, err, img.Bounds())
+	}
+}
+
+func TestAspectCropInvalid(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	tests := []struct {
+		name string
+		spec CropSpec
+	}{
+		{"no colon", CropSpec{Aspect: "16x9"}},
+		{"zero term", CropSpec{Aspect: "0:1"}},
+		{"negative term", CropSpec{Aspect: "-4:3"}},
+		{"width term too large", CropSpec{Aspect: "10001:1"}},
+		{"height term too large", CropSpec{Aspect: "1:10001"}},
+		{"overflowing term", CropSpec{Aspect: "9223372036854775807:1"}},
+		{"too wide to fit", CropSpec{Aspect: "10000:1"}},
+		{"too tall to fit", CropSpec{Aspect: "1:10000"}},
+		{"unknown gravity", CropSpec{Aspect: "1:1", Gravity: "up"}},
+		{"focal gravity without a focal point", CropSpec{Aspect: "1:1", Gravity: "focal"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := tt.spec.Rectangle(img); !errors.Is(err, ErrInvalidInput) {
+				t.Fatalf("got %v, %v; want an invalid input error", got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log/slog"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+)
+
+// Derivatives are images generated from the original by an operation such
+// as a crop. They live in posts/{id}/derivatives/{derivativeID} and
+// derived/{derivativeID}_{id}.jpg, next to the resized_images and
+// watermarks variants.
+const derivativesCollection = "derivatives"
+
+// DerivativeSummary describes a stored derivative.
+type DerivativeSummary struct {
+	ID     string                 `json:"id"`
+	Kind   string                 `json:"kind"`
+	Path   string                 `json:"path"`
+	Params map[string]interface{} `json:"params"`
+}
+
+func originalObjectPath(imageID string) string {
+	return fmt.Sprintf("%s.jpg", imageID)
+}
+
+func derivativeObjectPath(imageID, derivativeID string) string {
+	return fmt.Sprintf("derived/%s_%s.jpg", derivativeID, imageID)
+}
+
+// downloadOriginal fetches and decodes the uploaded image.
+func downloadOriginal(ctx context.Context, client *storage.Client, imageID string) (image.Image, error) {
+	img, err := downloadImage(ctx, client, Settings.BucketName, originalObjectPath(imageID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image %s: %w", imageID, err)
+	}
+	return img, nil
+}
+
+// storeDerivative uploads img and records it with the parameters needed to
+// reproduce it.
+func storeDerivative(ctx context.Context, storageClient *storage.Client, firestoreClient *firestore.Client, imageID string, derivative DerivativeSummary, img image.Image) (*DerivativeSummary, error) {
+	derivative.Path = derivativeObjectPath(imageID, derivative.ID)
+	if err := uploadJPEG(ctx, storageClient, Settings.BucketName, derivative.Path, img, Settings.JPEG); err != nil {
+		return nil, fmt.Errorf("failed to upload %s derivative: %w", derivative.Kind, err)
+	}
+
+	docRef := firestoreClient.Collection("posts").Doc(imageID).Collection(derivativesCollection).Doc(derivative.ID)
+	_, err := docRef.Set(ctx, map[string]interface{}{
+		"ID":          derivative.ID,
+		"Description": fmt.Sprintf("%s derivative", derivative.Kind),
+		"Kind":        derivative.Kind,
+		"Path":        derivative.Path,
+		"Params":      derivative.Params,
+	})
+	if err != nil {
+		return nil, backendError(err, "failed to save derivative details to Firestore")
+	}
+
+	slog.InfoContext(ctx, "derivative saved", "image_id", imageID, "derivative", derivative.ID, "path", derivative.Path)
+	return &derivative, nil
+}
+
+// GetDerivativeDetailsFromFirestore returns the document describing a derivative.
+func GetDerivativeDetailsFromFirestore(ctx context.Context, client *firestore.Client, imageID, derivativeID string) (map[string]interface{}, error) {
+	doc, err := client.Collection("posts").Doc(imageID).Collection(derivativesCollection).Doc(derivativeID).Get(ctx)
+	if err != nil {
+		return nil, backendError(err, "failed to get derivative %s of %s from Firestore", derivativeID, imageID)
+	}
+	return doc.Data(), nil
+}
+
+func derivativeFromDoc(id string, data map[string]interface{}) DerivativeSummary {
+	kind, _ := data["Kind"].(string)
+	path, _ := data["Path"].(string)
+	params, _ := data["Params"].(map[string]interface{})
+	return DerivativeSummary{ID: id, Kind: kind, Path: path, Params: params}
+}
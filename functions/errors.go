@@ -0,0 +1,68 @@
+package functions
+
+import (
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Error kinds. Use errors.Is to test which kind an error returned by this
+// package belongs to.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrInvalidInput = errors.New("invalid input")
+	ErrUpstream     = errors.New("upstream failure")
+)
+
+// Error is a domain error. Detail is safe to show to API clients; the
+// wrapped cause is meant for logs only.
+type Error struct {
+	Kind   error
+	Detail string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Detail, e.Err)
+	}
+	return e.Detail
+}
+
+func (e *Error) Is(target error) bool { return target == e.Kind }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func newError(kind error, cause error, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Detail: fmt.Sprintf(format, args...), Err: cause}
+}
+
+// backendError classifies a storage or Firestore failure, turning missing
+// objects and documents into ErrNotFound.
+func backendError(err error, format string, args ...interface{}) error {
+	if errors.Is(err, storage.ErrObjectNotExist) || status.Code(err) == codes.NotFound {
+		return newError(ErrNotFound, err, format, args...)
+	}
+	return newError(ErrUpstream, err, format, args...)
+}
+
+// ErrorType returns a short label for the kind of err, for metrics and logs.
+func ErrorType(err error) string {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrInvalidInput):
+		return "invalid_input"
+	case errors.Is(err, ErrUnsupportedFormat):
+		return "unsupported_format"
+	case errors.Is(err, ErrImageTooLarge):
+		return "too_large"
+	case errors.Is(err, ErrUpstream):
+		return "upstream"
+	default:
+		return "internal"
+	}
+}
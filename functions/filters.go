@@ -0,0 +1,191 @@
+package functions
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"github.com/disintegration/imaging"
+)
+
+// maxFilterOps bounds the work a single request can ask for.
+const maxFilterOps = 20
+
+// FilterOp is one step of a transformation. Which fields apply depends on Op:
+//
+//	rotate      Angle in degrees counter-clockwise; Background fills the
+//	            uncovered corners of arbitrary angles (hex, default white)
+//	flip        Axis horizontal or vertical
+//	grayscale   no parameters
+//	blur        Sigma of the gaussian kernel
+//	sharpen     unsharp mask with Sigma and Amount (strength, default 1)
+//	brightness  Amount in percent, -100 to 100
+//	contrast    Amount in percent, -100 to 100
+//	saturation  Amount in percent, -100 to 500
+//	gamma       Amount, 1 leaves the image unchanged
+type FilterOp struct {
+	Op         string  `json:"op" firestore:"op"`
+	Angle      float64 `json:"angle,omitempty" firestore:"angle,omitempty"`
+	Background string  `json:"background,omitempty" firestore:"background,omitempty"`
+	Axis       string  `json:"axis,omitempty" firestore:"axis,omitempty"`
+	Sigma      float64 `json:"sigma,omitempty" firestore:"sigma,omitempty"`
+	Amount     float64 `json:"amount,omitempty" firestore:"amount,omitempty"`
+}
+
+// apply runs the operation, or explains why its parameters are invalid.
+func (op FilterOp) apply(img image.Image) (image.Image, error) {
+	invalid := func(format string, args ...interface{}) (image.Image, error) {
+		return nil, newError(ErrInvalidInput, nil, op.Op+": "+format, args...)
+	}
+	switch op.Op {
+	case "rotate":
+		switch math.Mod(math.Mod(op.Angle, 360)+360, 360) {
+		case 0:
+			return img, nil
+		case 90:
+			return imaging.Rotate90(img), nil
+		case 180:
+			return imaging.Rotate180(img), nil
+		case 270:
+			return imaging.Rotate270(img), nil
+		}
+		background := color.Color(color.White)
+		if op.Background != "" {
+			c, err := parseHexColor(op.Background)
+			if err != nil {
+				return invalid("%v", err)
+			}
+			background = c
+		}
+		return imaging.Rotate(img, op.Angle, background), nil
+	case "flip":
+		switch op.Axis {
+		case "horizontal":
+			return imaging.FlipH(img), nil
+		case "vertical":
+			return imaging.FlipV(img), nil
+		}
+		return invalid("axis must be horizontal or vertical, got %q", op.Axis)
+	case "grayscale":
+		return imaging.Grayscale(img), nil
+	case "blur":
+		if op.Sigma <= 0 || op.Sigma > 100 {
+			return invalid("sigma must be in (0, 100], got %v", op.Sigma)
+		}
+		return imaging.Blur(img, op.Sigma), nil
+	case "sharpen":
+		if op.Sigma <= 0 || op.Sigma > 100 {
+			return invalid("sigma must be in (0, 100], got %v", op.Sigma)
+		}
+		amount := op.Amount
+		if amount == 0 {
+			amount = 1
+		}
+		if amount < 0 || amount > 10 {
+			return invalid("amount must be in (0, 10], got %v", op.Amount)
+		}
+		return unsharpMask(img, op.Sigma, amount), nil
+	case "brightness", "contrast":
+		if op.Amount < -100 || op.Amount > 100 {
+			return invalid("amount must be between -100 and 100, got %v", op.Amount)
+		}
+		if op.Op == "brightness" {
+			return imaging.AdjustBrightness(img, op.Amount), nil
+		}
+		return imaging.AdjustContrast(img, op.Amount), nil
+	case "saturation":
+		if op.Amount < -100 || op.Amount > 500 {
+			return invalid("amount must be between -100 and 500, got %v", op.Amount)
+		}
+		return imaging.AdjustSaturation(img, op.Amount), nil
+	case "gamma":
+		if op.Amount <= 0 || op.Amount > 10 {
+			return invalid("amount must be in (0, 10], got %v", op.Amount)
+		}
+		return imaging.AdjustGamma(img, op.Amount), nil
+	}
+	return nil, newError(ErrInvalidInput, nil, "unknown operation %q", op.Op)
+}
+
+// applyFilters runs ops on img in order, stopping at the first invalid one.
+func applyFilters(img image.Image, ops []FilterOp) (image.Image, error) {
+	for _, op := range ops {
+		var err error
+		if img, err = op.apply(img); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// unsharpMask adds amount times the difference between the image and its
+// gaussian blur, which is what "sharpen" means in most editors.
+func unsharpMask(img image.Image, sigma, amount float64) *image.NRGBA {
+	src := imaging.Clone(img)
+	blurred := imaging.Blur(src, sigma)
+	for i := range src.Pix {
+		if i%4 == 3 {
+			continue // keep alpha
+		}
+		v := float64(src.Pix[i]) + amount*(float64(src.Pix[i])-float64(blurred.Pix[i]))
+		src.Pix[i] = uint8(math.Round(math.Min(math.Max(v, 0), 255)))
+	}
+	return src
+}
+
+func parseHexColor(s string) (color.NRGBA, error) {
+	hexDigits := strings.TrimPrefix(s, "#")
+	if len(hexDigits) == 6 {
+		hexDigits += "ff"
+	}
+	v, err := strconv.ParseUint(hexDigits, 16, 32)
+	if len(hexDigits) != 8 || err != nil {
+		return color.NRGBA{}, newError(ErrInvalidInput, nil, "colors must look like #rrggbb or #rrggbbaa, got %q", s)
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// filterDerivativeID names the result of an operation list, so applying the
+// same list twice overwrites the same derivative.
+func filterDerivativeID(ops []FilterOp) string {
+	canonical, _ := json.Marshal(ops)
+	sum := sha256.Sum256(canonical)
+	return "filter_" + hex.EncodeToString(sum[:6])
+}
+
+// FilterImage applies ops in order to the original image and stores the
+// result as a derivative whose params record the operation list.
+func FilterImage(ctx context.Context, imageID string, ops []FilterOp, storageClient *storage.Client, firestoreClient *firestore.Client) (derivative *DerivativeSummary, err error) {
+	ctx, span := startImageSpan(ctx, "FilterImage", imageID, "filter")
+	defer func() { endSpan(span, err) }()
+	defer beginJob()()
+
+	if len(ops) == 0 || len(ops) > maxFilterOps {
+		return nil, newError(ErrInvalidInput, nil, "between 1 and %d operations are required", maxFilterOps)
+	}
+	img, err := downloadOriginal(ctx, storageClient, imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, stage := startStage(ctx, "filter")
+	img, err = applyFilters(img, ops)
+	stage.end(err)
+	if err != nil {
+		return nil, err
+	}
+
+	return storeDerivative(ctx, storageClient, firestoreClient, imageID, DerivativeSummary{
+		ID:     filterDerivativeID(ops),
+		Kind:   "filter",
+		Params: map[string]interface{}{"operations": ops},
+	}, img)
+}
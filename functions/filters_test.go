@@ -0,0 +1,143 @@
+package functions
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    color.NRGBA
+		wantErr bool
+	}{
+		{"#ff8000", color.NRGBA{255, 128, 0, 255}, false},
+		{"FF8000", color.NRGBA{255, 128, 0, 255}, false},
+		{"#ff800080", color.NRGBA{255, 128, 0, 128}, false},
+		{"#fff", color.NRGBA{}, true},
+		{"#gg0000", color.NRGBA{}, true},
+		{"#ff800080ff", color.NRGBA{}, true},
+		{"", color.NRGBA{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseHexColor(tt.in)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("parseHexColor(%q) = %v, %v; want an invalid input error", tt.in, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseHexColor(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestFilterOpValidation(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	tests := []struct {
+		name  string
+		op    FilterOp
+		valid bool
+	}{
+		{"rotate right angle", FilterOp{Op: "rotate", Angle: -90}, true},
+		{"rotate with background", FilterOp{Op: "rotate", Angle: 30, Background: "#000000"}, true},
+		{"rotate with a bad background", FilterOp{Op: "rotate", Angle: 30, Background: "black"}, false},
+		{"flip horizontal", FilterOp{Op: "flip", Axis: "horizontal"}, true},
+		{"flip diagonal", FilterOp{Op: "flip", Axis: "diagonal"}, false},
+		{"flip without axis", FilterOp{Op: "flip"}, false},
+		{"grayscale", FilterOp{Op: "grayscale"}, true},
+		{"blur", FilterOp{Op: "blur", Sigma: 100}, true},
+		{"blur without sigma", FilterOp{Op: "blur"}, false},
+		{"blur sigma too large", FilterOp{Op: "blur", Sigma: 100.5}, false},
+		{"sharpen default amount", FilterOp{Op: "sharpen", Sigma: 1}, true},
+		{"sharpen negative amount", FilterOp{Op: "sharpen", Sigma: 1, Amount: -1}, false},
+		{"sharpen amount too large", FilterOp{Op: "sharpen", Sigma: 1, Amount: 11}, false},
+		{"sharpen without sigma", FilterOp{Op: "sharpen", Amount: 1}, false},
+		{"brightness bounds", FilterOp{Op: "brightness", Amount: -100}, true},
+		{"brightness too high", FilterOp{Op: "brightness", Amount: 101}, false},
+		{"contrast too low", FilterOp{Op: "contrast", Amount: -101}, false},
+		{"saturation bounds", FilterOp{Op: "saturation", Amount: 500}, true},
+		{"saturation too high", FilterOp{Op: "saturation", Amount: 501}, false},
+		{"saturation too low", FilterOp{Op: "saturation", Amount: -101}, false},
+		{"gamma", FilterOp{Op: "gamma", Amount: 10}, true},
+		{"gamma zero", FilterOp{Op: "gamma"}, false},
+		{"gamma too high", FilterOp{Op: "gamma", Amount: 11}, false},
+		{"unknown operation", FilterOp{Op: "sepia"}, false},
+		{"no operation", FilterOp{}, false},
+		{"operations are case sensitive", FilterOp{Op: "Grayscale"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op.apply(img)
+			if tt.valid && (err != nil || got == nil) {
+				t.Errorf("got %v, want success", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("got %v, want an invalid input error", err)
+			}
+		})
+	}
+}
+
+func TestApplyFiltersInOrder(t *testing.T) {
+	red, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, red)
+	img.SetNRGBA(1, 0, blue)
+
+	at := func(img image.Image, x, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(img.At(img.Bounds().Min.X+x, img.Bounds().Min.Y+y)).(color.NRGBA)
+	}
+	flip := FilterOp{Op: "flip", Axis: "horizontal"}
+	rotate := FilterOp{Op: "rotate", Angle: 90}
+
+	// Rotating counter-clockwise brings the right pixel to the top; flipping
+	// first swaps which pixel that is
+	tests := []struct {
+		name        string
+		ops         []FilterOp
+		top, bottom color.NRGBA
+	}{
+		{"rotate then flip", []FilterOp{rotate, flip}, blue, red},
+		{"flip then rotate", []FilterOp{flip, rotate}, red, blue},
+	}
+	for _, tt := range tests {
+		got, err := applyFilters(img, tt.ops)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got.Bounds().Dx() != 1 || got.Bounds().Dy() != 2 {
+			t.Fatalf("%s: got bounds %v, want 1x2", tt.name, got.Bounds())
+		}
+		if top, bottom := at(got, 0, 0), at(got, 0, 1); top != tt.top || bottom != tt.bottom {
+			t.Errorf("%s: got %v over %v, want %v over %v", tt.name, top, bottom, tt.top, tt.bottom)
+		}
+	}
+
+	got, err := applyFilters(img, []FilterOp{{Op: "grayscale"}, {Op: "brightness", Amount: -10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Red's luma is 0.299*255 = 76, less 10% of 255
+	if c := at(got, 0, 0); c.R != c.G || c.G != c.B || c.R < 49 || c.R > 52 {
+		t.Errorf("grayscale then brightness: got %v, want gray near 51", c)
+	}
+
+	// The corners uncovered by an arbitrary rotation take the background
+	square := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	got, err = applyFilters(square, []FilterOp{{Op: "rotate", Angle: 45, Background: "#00ff00"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := at(got, 0, 0); c != (color.NRGBA{0, 255, 0, 255}) {
+		t.Errorf("rotated corner: got %v, want the green background", c)
+	}
+
+	// An invalid step stops the list
+	if _, err := applyFilters(img, []FilterOp{flip, {Op: "sepia"}, rotate}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("got %v, want the unknown operation to fail the list", err)
+	}
+}
@@ -0,0 +1,92 @@
+package functions
+
+import (
+	"context"
+	"image"
+	"log/slog"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// GravityFocal centers a crop on the image's focal point.
+const GravityFocal = "focal"
+
+// FocalPoint is the position of the subject, normalized to [0, 1] from the
+// top-left corner of the image.
+type FocalPoint struct {
+	X float64 `json:"x" firestore:"X"`
+	Y float64 `json:"y" firestore:"Y"`
+}
+
+// GetFocalPoint returns the focal point stored on the image, or nil when none
+// has been set.
+func GetFocalPoint(ctx context.Context, client *firestore.Client, imageID string) (*FocalPoint, error) {
+	doc, err := client.Collection("posts").Doc(imageID).Get(ctx)
+	if err != nil {
+		return nil, backendError(err, "failed to get image %s from Firestore", imageID)
+	}
+	var data struct {
+		FocalPoint *FocalPoint `firestore:"FocalPoint"`
+	}
+	if err := doc.DataTo(&data); err != nil {
+		return nil, newError(ErrUpstream, err, "failed to read image %s from Firestore", imageID)
+	}
+	return data.FocalPoint, nil
+}
+
+// SetFocalPoint stores the focal point on the image and regenerates the
+// aspect-ratio crops, which are positioned relative to it. Crops with an
+// explicit rectangle do not depend on the focal point and are left alone.
+// It returns the IDs of the regenerated derivatives.
+func SetFocalPoint(ctx context.Context, imageID string, point FocalPoint, storageClient *storage.Client, firestoreClient *firestore.Client) (regenerated []string, err error) {
+	ctx, span := startImageSpan(ctx, "SetFocalPoint", imageID, "focal")
+	defer func() { endSpan(span, err) }()
+	defer beginJob()()
+
+	if point.X < 0 || point.X > 1 || point.Y < 0 || point.Y > 1 {
+		return nil, newError(ErrInvalidInput, nil, "focal point coordinates must be between 0 and 1")
+	}
+	docRef := firestoreClient.Collection("posts").Doc(imageID)
+	if _, err := docRef.Update(ctx, []firestore.Update{{Path: "FocalPoint", Value: point}}); err != nil {
+		return nil, backendError(err, "failed to save focal point of %s", imageID)
+	}
+	slog.InfoContext(ctx, "focal point saved", "image_id", imageID, "x", point.X, "y", point.Y)
+
+	var specs []CropSpec
+	iter := docRef.Collection(derivativesCollection).Where("Kind", "==", "crop").Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, backendError(err, "failed to list derivatives of %s", imageID)
+		}
+		params, _ := doc.Data()["Params"].(map[string]interface{})
+		aspect, _ := params["aspect"].(string)
+		gravity, _ := params["gravity"].(string)
+		if aspect != "" {
+			specs = append(specs, CropSpec{Aspect: aspect, Gravity: gravity, Focal: &point})
+		}
+	}
+	if len(specs) == 0 {
+		return []string{}, nil
+	}
+
+	var img image.Image
+	if img, err = downloadOriginal(ctx, storageClient, imageID); err != nil {
+		return nil, err
+	}
+	regenerated = make([]string, 0, len(specs))
+	for _, spec := range specs {
+		derivative, err := cropDerivative(ctx, imageID, img, spec, storageClient, firestoreClient)
+		if err != nil {
+			return regenerated, err
+		}
+		regenerated = append(regenerated, derivative.ID)
+	}
+	return regenerated, nil
+}
@@ -0,0 +1,98 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"image"
+	"testing"
+)
+
+func TestKeepInFrame(t *testing.T) {
+	tests := []struct {
+		name                 string
+		start, length, point int
+		want                 int
+	}{
+		{"inside", 10, 50, 30, 10},
+		{"on the first pixel", 10, 50, 10, 10},
+		{"on the last pixel", 10, 50, 59, 10},
+		{"one before", 10, 50, 9, 9},
+		{"one past", 10, 50, 60, 11},
+		{"image start", 40, 50, 0, 0},
+		{"image end", 0, 50, 199, 150},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := keepInFrame(tt.start, tt.length, tt.point)
+			if got != tt.want {
+				t.Fatalf("keepInFrame(%d, %d, %d) = %d, want %d", tt.start, tt.length, tt.point, got, tt.want)
+			}
+			if tt.point < got || tt.point >= got+tt.length {
+				t.Fatalf("window %d+%d misses %d", got, tt.length, tt.point)
+			}
+		})
+	}
+}
+
+func TestAspectCropFocalGravity(t *testing.T) {
+	// 200x100, not at the origin
+	img := image.NewRGBA(image.Rect(10, 20, 210, 120))
+	tests := []struct {
+		name  string
+		focal FocalPoint
+		want  image.Rectangle
+	}{
+		{"centered on the point", FocalPoint{0.4, 0.5}, image.Rect(40, 20, 140, 120)},
+		{"clamped at the left edge", FocalPoint{0.1, 0.5}, image.Rect(10, 20, 110, 120)},
+		{"clamped at the right edge", FocalPoint{0.9, 0.5}, image.Rect(110, 20, 210, 120)},
+		{"top-left corner", FocalPoint{0, 0}, image.Rect(10, 20, 110, 120)},
+		{"bottom-right corner", FocalPoint{1, 1}, image.Rect(110, 20, 210, 120)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			focal := tt.focal
+			// No gravity defaults to the focal point
+			for _, gravity := range []string{"", GravityFocal} {
+				got, err := CropSpec{Aspect: "1:1", Gravity: gravity, Focal: &focal}.Rectangle(img)
+				if err != nil || got != tt.want {
+					t.Fatalf("gravity %q: got %v, %v; want %v", gravity, got, err, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// TestAspectCropKeepsFocalPoint crops with every gravity around focal points
+// on the edges and corners; the focal pixel must always be in the crop.
+func TestAspectCropKeepsFocalPoint(t *testing.T) {
+	img := image.NewRGBA(image.Rect(10, 20, 210, 120))
+	gravities := []string{"", GravityFocal, GravitySmart}
+	for gravity := range gravityAnchors {
+		gravities = append(gravities, gravity)
+	}
+	for _, aspect := range []string{"1:1", "4:1", "1:2", "2:1"} {
+		for _, fx := range []float64{0, 0.25, 0.5, 0.75, 1} {
+			for _, fy := range []float64{0, 0.25, 0.5, 0.75, 1} {
+				focal := FocalPoint{fx, fy}
+				pixel := image.Pt(focalPixel(fx, 200), focalPixel(fy, 100)).Add(img.Rect.Min)
+				for _, gravity := range gravities {
+					got, err := CropSpec{Aspect: aspect, Gravity: gravity, Focal: &focal}.Rectangle(img)
+					if err != nil {
+						t.Fatalf("%s %q at %v: %v", aspect, gravity, focal, err)
+					}
+					if !pixel.In(got) || !got.In(img.Rect) {
+						t.Errorf("%s %q at %v: crop %v misses focal pixel %v or leaves the image", aspect, gravity, focal, got, pixel)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSetFocalPointRange(t *testing.T) {
+	for _, point := range []FocalPoint{{-0.1, 0.5}, {0.5, -0.1}, {1.01, 0.5}, {0.5, 1.01}} {
+		if _, err := SetFocalPoint(context.Background(), "image_1", point, nil, nil); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("SetFocalPoint(%v): got %v, want invalid input", point, err)
+		}
+	}
+}
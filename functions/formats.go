@@ -0,0 +1,32 @@
+package functions
+
+import (
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"slices"
+
+	// github.com/gen2brain/webp decodes the lossy, lossless and animated
+	// WebP files golang.org/x/image/webp only partly handles
+	_ "github.com/gen2brain/webp"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+)
+
+// decodableFormats are the upload MIME types a decoder is registered for.
+var decodableFormats = []string{"image/jpeg", "image/png", "image/gif", "image/bmp", "image/tiff", "image/webp"}
+
+// AcceptedFormats returns the MIME types uploads may use: those allowed by
+// Settings.Limits that can also be decoded.
+func AcceptedFormats() []string {
+	if len(Settings.Limits.AllowedFormats) == 0 {
+		return slices.Clone(decodableFormats)
+	}
+	var formats []string
+	for _, format := range decodableFormats {
+		if slices.Contains(Settings.Limits.AllowedFormats, format) {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
@@ -0,0 +1,66 @@
+package functions
+
+import (
+	"encoding/base64"
+	"errors"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// formatFixtures maps every decodable MIME type to a 24x16 sample file in
+// testdata and the format name image.Decode reports for it.
+var formatFixtures = map[string]struct{ file, format string }{
+	"image/jpeg": {"sample.jpg", "jpeg"},
+	"image/png":  {"sample.png", "png"},
+	"image/gif":  {"sample.gif", "gif"},
+	"image/bmp":  {"sample.bmp", "bmp"},
+	"image/tiff": {"sample.tiff", "tiff"},
+	"image/webp": {"sample.webp", "webp"},
+}
+
+func TestDecodableFormats(t *testing.T) {
+	for _, mime := range decodableFormats {
+		fixture, ok := formatFixtures[mime]
+		if !ok {
+			t.Errorf("no fixture for %s", mime)
+			continue
+		}
+		t.Run(mime, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", fixture.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := CheckImageData(data, Settings.Limits); err != nil {
+				t.Errorf("CheckImageData: %v", err)
+			}
+
+			encoded := base64.StdEncoding.EncodeToString(data)
+			for _, payload := range []string{encoded, "data:" + mime + ";base64," + encoded} {
+				img, format, err := DecodeBase64Image(payload)
+				if err != nil {
+					t.Fatalf("DecodeBase64Image: %v", err)
+				}
+				if format != fixture.format {
+					t.Errorf("decoded as %s, want %s", format, fixture.format)
+				}
+				if img.Bounds() != image.Rect(0, 0, 24, 16) {
+					t.Errorf("decoded bounds %v, want 24x16", img.Bounds())
+				}
+			}
+		})
+	}
+}
+
+func TestCheckImageDataRejectsDisallowedFormat(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "sample.tiff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := Settings.Limits
+	limits.AllowedFormats = []string{"image/jpeg", "image/png"}
+	if err := CheckImageData(data, limits); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("got %v, want an unsupported format error", err)
+	}
+}
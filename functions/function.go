@@ -1,20 +1,21 @@
 package functions
 
 import (
+	"Project/resample"
 	"context"
-	"encoding/base64"
 	"fmt"
 	"image"
-	"image/color"
 	"image/draw"
-	"image/jpeg"
-	"log"
+	"log/slog"
+	"math"
+	"regexp"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/storage"
-	"github.com/disintegration/imaging"
-	"github.com/nfnt/resize"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type ImageDocument struct {
@@ -26,9 +27,7 @@ type ImageDocument struct {
 	Path        string `firestore:"Path"`
 }
 
-func SaveImageDetailsToFirestore(client *firestore.Client, id, description, smallPath, mediumPath, largePath string) error {
-	ctx := context.Background()
-
+func SaveImageDetailsToFirestore(ctx context.Context, client *firestore.Client, id, description, smallPath, mediumPath, largePath string) error {
 	// Reference the Firestore collection
 	docRef := client.Collection("posts").Doc(id)
 
@@ -44,16 +43,14 @@ func SaveImageDetailsToFirestore(client *firestore.Client, id, description, smal
 	// Write to Firestore
 	_, err := docRef.Set(ctx, imageDoc)
 	if err != nil {
-		return fmt.Errorf("failed to save image details to Firestore: %v", err)
+		return backendError(err, "failed to save image details to Firestore")
 	}
 
-	log.Printf("Image details saved to Firestore: ID = %s\n", id)
+	slog.InfoContext(ctx, "image details saved to Firestore", "image_id", id)
 	return nil
 }
 
-func SaveWatermarkedImageDetailsToFirestore(client *firestore.Client, parentID, watermarkID, description, path string) error {
-	ctx := context.Background()
-
+func SaveWatermarkedImageDetailsToFirestore(ctx context.Context, client *firestore.Client, parentID, watermarkID, description, path string) error {
 	// Reference the Firestore collection
 	docRef := client.Collection("posts").Doc(parentID).Collection("watermarks").Doc(watermarkID)
 
@@ -67,10 +64,10 @@ func SaveWatermarkedImageDetailsToFirestore(client *firestore.Client, parentID,
 	// Write to Firestore
 	_, err := docRef.Set(ctx, WatermarkDoc)
 	if err != nil {
-		return fmt.Errorf("failed to save image details to Firestore: %v", err)
+		return backendError(err, "failed to save image details to Firestore")
 	}
 
-	log.Printf("Watermarked image details saved to Firestore: parentID = %s, watermarkID = %s\n", parentID, watermarkID)
+	slog.InfoContext(ctx, "watermarked image details saved to Firestore", "image_id", parentID, "variant", watermarkID)
 	return nil
 }
 func CalculateWatermarkPositions(imgWidth, imgHeight, wmWidth, wmHeight, numWatermarks int) []image.Point {
@@ -97,16 +94,28 @@ func CalculateWatermarkPositions(imgWidth, imgHeight, wmWidth, wmHeight, numWate
 
 	return positions
 }
+
+// resizeFuncForSize returns the resize function for a size preset, or an
+// ErrInvalidInput error when the size is unknown.
+func resizeFuncForSize(sizename string) (func(image.Image) image.Image, error) {
+	width, ok := Settings.SizePresets[strings.ToLower(sizename)]
+	if !ok {
+		return nil, newError(ErrInvalidInput, nil, "invalid size: %s", sizename)
+	}
+	return func(img image.Image) image.Image {
+		return resample.Resize(img, width, 0, Settings.ResizeFilter)
+	}, nil
+}
 func ResizeSmallImage(img image.Image) image.Image {
-	small := resize.Resize(100, 0, img, resize.Lanczos3)
+	small := resample.Resize(img, Settings.SizePresets["small"], 0, Settings.ResizeFilter)
 	return small
 }
 func ResizeMediumImage(img image.Image) image.Image {
-	medium := resize.Resize(500, 0, img, resize.Lanczos3)
+	medium := resample.Resize(img, Settings.SizePresets["medium"], 0, Settings.ResizeFilter)
 	return medium
 }
 func ResizeLargeImage(img image.Image) image.Image {
-	large := resize.Resize(1500, 0, img, resize.Lanczos3)
+	large := resample.Resize(img, Settings.SizePresets["large"], 0, Settings.ResizeFilter)
 	return large
 }
 func AddWatermark(img image.Image, watermark image.Image) image.Image {
@@ -123,72 +132,36 @@ func AddWatermark(img image.Image, watermark image.Image) image.Image {
 		numWatermarks = 5
 	}
 
-	// Calculate proportional watermark size and resize it
-	watermarkWidth := int(float64(imgWidth) * 0.2) // 20% of the image width
-	watermark = imaging.Resize(watermark, watermarkWidth, 0, imaging.Lanczos)
-	alpha := 0.7
-	watermarkedImage := AddTransparency(watermark, alpha)
+	// Scale the watermark proportionally, reusing earlier scalings
+	watermarkWidth := max(int(float64(imgWidth)*Settings.Watermark.WidthRatio), 1)
+	scaled := scaledWatermark(watermark, watermarkWidth)
+
 	// Create a new image to hold the final result
-	finalImg := image.NewRGBA(img.Bounds())
-	draw.Draw(finalImg, finalImg.Bounds(), img, image.Point{}, draw.Src)
+	finalImg := image.NewRGBA(image.Rect(0, 0, imgWidth, imgHeight))
+	draw.Draw(finalImg, finalImg.Bounds(), img, img.Bounds().Min, draw.Src)
 
 	// Generate positions for the watermarks
-	positions := CalculateWatermarkPositions(imgWidth, imgHeight, watermark.Bounds().Dx(), watermark.Bounds().Dy(), numWatermarks)
+	positions := CalculateWatermarkPositions(imgWidth, imgHeight, scaled.Bounds().Dx(), scaled.Bounds().Dy(), numWatermarks)
 
 	// Draw the watermark at each position
 	for _, pos := range positions {
-		draw.Draw(finalImg, watermark.Bounds().Add(pos), watermarkedImage, image.Point{}, draw.Over)
+		drawWatermark(finalImg, scaled.Bounds().Sub(scaled.Bounds().Min).Add(pos), scaled, Settings.Watermark.Opacity)
 	}
-
 	return finalImg
 }
-func AddTransparency(img image.Image, alpha float64) *image.RGBA {
-	bounds := img.Bounds()
-	rgba := image.NewRGBA(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			originalColor := img.At(x, y)
-			_, _, _, a := originalColor.RGBA()
-
-			// Only apply transparency if the pixel is not fully transparent
-			if a > 0 {
-				r, g, b, _ := originalColor.RGBA()
-				transparentColor := color.RGBA{
-					R: uint8(r >> 8),
-					G: uint8(g >> 8),
-					B: uint8(b >> 8),
-					A: uint8(alpha * 255),
-				}
-				rgba.Set(x, y, transparentColor)
-			}
-		}
-	}
-	return rgba
-}
-func UploadImageToFirebase(client *storage.Client, filename string, img image.Image) (string, error) {
-	ctx := context.Background()
 
+func UploadImageToFirebase(ctx context.Context, client *storage.Client, filename string, img image.Image) (string, error) {
 	// Create a bucket reference
-	bucketName := "halogen-device-438608-v9.appspot.com" // Replace with your bucket name
-	bucket := client.Bucket(bucketName)
-
-	// Create a new file in the bucket
-	obj := bucket.Object(filename)
-	writer := obj.NewWriter(ctx)
-	defer writer.Close()
+	bucketName := Settings.BucketName
 
 	// Encode and write the image to Firebase Storage as JPEG
-	err := jpeg.Encode(writer, img, nil)
-	if err != nil {
+	if err := uploadJPEG(ctx, client, bucketName, filename, img, originalJPEGSettings()); err != nil {
 		return "", err
 	}
 
 	return filename, nil
 }
-func GetImageDetailsFromFireStore(client *firestore.Client, parentID string, sizename string) (map[string]interface{}, error) {
-	ctx := context.Background()
-
+func GetImageDetailsFromFireStore(ctx context.Context, client *firestore.Client, parentID string, sizename string) (map[string]interface{}, error) {
 	// Reference the Firestore document for the small image
 	docRef := client.Collection("posts").Doc(parentID).Collection("resized_images").Doc(sizename)
 
@@ -196,19 +169,17 @@ func GetImageDetailsFromFireStore(client *firestore.Client, parentID string, siz
 	doc, err := docRef.Get(ctx)
 	if err != nil {
 
-		return nil, fmt.Errorf("failed to get %s image details from Firestore: %v", sizename, err)
+		return nil, backendError(err, "failed to get %s image details from Firestore", sizename)
 	}
 
 	// Extract the document data
 	imageDetails := doc.Data()
 
-	log.Printf("%s image details retrieved from Firestore: parentID = %s\n", sizename, parentID)
+	slog.DebugContext(ctx, "image details retrieved from Firestore", "image_id", parentID, "size", sizename)
 	return imageDetails, nil
 }
 
-func SaveUploadedImageDetailsToFirestore(client *firestore.Client, id, description, Filepath string) error {
-	ctx := context.Background()
-
+func SaveUploadedImageDetailsToFirestore(ctx context.Context, client *firestore.Client, id, description, Filepath string, width, height int) error {
 	// Reference the Firestore collection
 	docRef := client.Collection("posts").Doc(id)
 
@@ -217,59 +188,46 @@ func SaveUploadedImageDetailsToFirestore(client *firestore.Client, id, descripti
 		"ID":          id,
 		"Description": description,
 		"Filepath":    Filepath,
+		"Width":       width,
+		"Height":      height,
 	}
 
 	// Write to Firestore
 	_, err := docRef.Set(ctx, imageDoc)
 	if err != nil {
-		return fmt.Errorf("failed to save image details to Firestore: %v", err)
+		return backendError(err, "failed to save image details to Firestore")
 	}
 
-	log.Printf("Image details saved to Firestore: ID = %s\n", id)
+	slog.InfoContext(ctx, "image details saved to Firestore", "image_id", id)
 	return nil
 }
-func UploadImageHandler(base64ImageData string, StorageClient *storage.Client, firestoreClient *firestore.Client, timestamp string) error {
-	if strings.HasPrefix(base64ImageData, "data:image/") {
-		commaIndex := strings.Index(base64ImageData, ",")
-		if commaIndex != -1 {
-			base64ImageData = base64ImageData[commaIndex+1:]
-		}
-	}
-
-	// Decode the Base64 string into image bytes
-	imageData, err := base64.StdEncoding.DecodeString(base64ImageData)
+func UploadImageHandler(ctx context.Context, base64ImageData string, StorageClient *storage.Client, firestoreClient *firestore.Client, timestamp string) (err error) {
+	defer beginJob()()
+	ctx, span := startImageSpan(ctx, "UploadImageHandler", "image_"+timestamp, "original")
+	defer func() { endSpan(span, err) }()
+	// Check limits and decode the image to check if it's a valid image
+	img, format, err := DecodeBase64Image(base64ImageData)
 	if err != nil {
-		return fmt.Errorf("unable to decode Base64 string: %v", err)
+		return err
 	}
 
-	// Create a reader from the decoded bytes
-	imageReader := strings.NewReader(string(imageData))
-
-	// Decode the image to check if it's a valid image
-	img, format, err := image.Decode(imageReader)
-	if err != nil {
-		return fmt.Errorf("invalid image format: %v", err)
-	}
-
-	log.Printf("Image decoded successfully: format = %s\n", format)
+	slog.InfoContext(ctx, "image decoded", "image_id", "image_"+timestamp, "format", format)
 	Filename := fmt.Sprintf("image_%s.jpg", timestamp)
-	Filepath, err := UploadImageToFirebase(StorageClient, Filename, img)
+	Filepath, err := UploadImageToFirebase(ctx, StorageClient, Filename, img)
 	if err != nil {
-		return fmt.Errorf("error uploading image: %v", err)
+		return fmt.Errorf("error uploading image: %w", err)
 	}
 	ID := fmt.Sprintf("image_%s", timestamp)
 	description := "Image uploaded successfully!!!"
-	err = SaveUploadedImageDetailsToFirestore(firestoreClient, ID, description, Filepath)
+	err = SaveUploadedImageDetailsToFirestore(ctx, firestoreClient, ID, description, Filepath, img.Bounds().Dx(), img.Bounds().Dy())
 	if err != nil {
-		return fmt.Errorf("error saving image details to Firestore: %v", err)
+		return fmt.Errorf("error saving image details to Firestore: %w", err)
 	}
 	return nil
 
 }
 
-func SaveResizedImageDetailsToFirestore(client *firestore.Client, parentID, sizeID, description, path string) error {
-	ctx := context.Background()
-
+func SaveResizedImageDetailsToFirestore(ctx context.Context, client *firestore.Client, parentID, sizeID, description, path string, width, height int) error {
 	// Reference the Firestore collection
 	docRef := client.Collection("posts").Doc(parentID).Collection("resized_images").Doc(sizeID)
 
@@ -278,121 +236,171 @@ func SaveResizedImageDetailsToFirestore(client *firestore.Client, parentID, size
 		"ID":          sizeID,
 		"Description": description,
 		"Path":        path,
+		"Width":       width,
+		"Height":      height,
 	}
 
 	// Write to Firestore
 	_, err := docRef.Set(ctx, resizedImageDoc)
 	if err != nil {
-		return fmt.Errorf("failed to save resized image details to Firestore: %v", err)
+		return backendError(err, "failed to save resized image details to Firestore")
 	}
 
-	log.Printf("Resized image details saved to Firestore: parentID = %s, sizeID = %s\n", parentID, sizeID)
+	slog.InfoContext(ctx, "resized image details saved to Firestore", "image_id", parentID, "size", sizeID)
 	return nil
 }
 
-func ProcessResizeImage(ImageID string, sizename string, StorageClient *storage.Client, firestoreClient *firestore.Client) error {
-	ctx := context.Background()
-	log.Printf("Received ImageID: %s", ImageID)
-	objectPath := fmt.Sprintf("%s.jpg", ImageID)
-	log.Printf("Attempting to retrieve image with path: %s", objectPath)
-	bucketName := "halogen-device-438608-v9.appspot.com"
-	reader, err := StorageClient.Bucket(bucketName).Object(objectPath).NewReader(ctx)
+func ProcessResizeImage(ctx context.Context, ImageID string, sizename string, StorageClient *storage.Client, firestoreClient *firestore.Client) error {
+	_, err := ResizeVariant(ctx, ImageID, sizename, StorageClient, firestoreClient)
+	return err
+}
+
+// ResizeVariant creates the sizename variant, starting from the closest
+// larger stored variant when Settings.ResizeSource allows it.
+func ResizeVariant(ctx context.Context, imageID string, sizename string, storageClient *storage.Client, firestoreClient *firestore.Client) (result *VariantResult, err error) {
+	ctx, span := startImageSpan(ctx, "ResizeVariant", imageID, sizename)
+	defer func() { endSpan(span, err) }()
+	resizeFunc, err := resizeFuncForSize(sizename)
 	if err != nil {
-		return fmt.Errorf("failed to get image from storage: %v", err)
+		return nil, err
 	}
-	defer reader.Close()
+	defer beginJob()()
+	start := time.Now()
 
-	img, _, err := image.Decode(reader)
+	result = &VariantResult{Name: sizename}
+	img, source, err := resizeSource(ctx, storageClient, firestoreClient, imageID, Settings.SizePresets[strings.ToLower(sizename)], sizename)
 	if err != nil {
-		return fmt.Errorf("failed to decode image: %v", err)
+		return nil, fmt.Errorf("failed to get image %s: %w", imageID, err)
 	}
-	var resizedImage image.Image
-	switch strings.ToLower(sizename) {
-	case "small":
-		resizedImage = ResizeSmallImage(img)
-	case "medium":
-		resizedImage = ResizeMediumImage(img)
-	case "large":
-		resizedImage = ResizeLargeImage(img)
-	default:
-		fmt.Errorf("invalid size: %s", sizename)
+	result.Source = source
+
+	_, stage := startStage(ctx, "resize")
+	resizedImage := resizeFunc(img)
+	stage.end(nil)
+
+	if result.Path, err = storeResized(ctx, storageClient, firestoreClient, imageID, sizename, resizedImage); err != nil {
+		return nil, err
 	}
 
-	Path := fmt.Sprintf("resized/%s_%s.jpg", sizename, ImageID)
-	writer := StorageClient.Bucket(bucketName).Object(Path).NewWriter(ctx)
-	defer writer.Close()
+	slog.InfoContext(ctx, "resized image saved", "image_id", imageID, "size", sizename, "path", result.Path, "source", source, "duration", time.Since(start))
+	return result, nil
+}
 
-	writer.ContentType = "image/jpeg"
-	if err := jpeg.Encode(writer, resizedImage, &jpeg.Options{Quality: 90}); err != nil {
-		return fmt.Errorf("failed to encode and upload resized image: %v", err)
+// storeResized uploads a resized image and records it under resized_images.
+func storeResized(ctx context.Context, storageClient *storage.Client, firestoreClient *firestore.Client, imageID, sizename string, img image.Image) (string, error) {
+	path := resizedObjectPath(imageID, sizename)
+	if err := uploadJPEG(ctx, storageClient, Settings.BucketName, path, img, jpegSettingsFor(sizename)); err != nil {
+		return "", fmt.Errorf("failed to encode and upload resized image: %w", err)
 	}
 
-	// Step 4: Save the resized image details to Firestore with the original image ID as the parentID
-	err = SaveResizedImageDetailsToFirestore(firestoreClient, ImageID, sizename, fmt.Sprintf("%s size image", sizename), Path)
+	// Save the resized image details to Firestore with the original image ID as the parentID
+	err := SaveResizedImageDetailsToFirestore(ctx, firestoreClient, imageID, sizename, fmt.Sprintf("%s size image", sizename), path, img.Bounds().Dx(), img.Bounds().Dy())
 	if err != nil {
-		return fmt.Errorf("failed to save resized image details to Firestore: %v", err)
+		return "", fmt.Errorf("failed to save resized image details to Firestore: %w", err)
 	}
+	return path, nil
+}
 
-	fmt.Println("Resized image saved successfully:", Path)
-	return nil
+// ProcessImageWithWatermark watermarks the sizename variant of an image,
+// creating the resize first when it does not exist yet.
+func ProcessImageWithWatermark(ctx context.Context, imageID string, sizename string, storageClient *storage.Client, firestoreClient *firestore.Client) error {
+	_, err := WatermarkVariant(ctx, imageID, sizename, storageClient, firestoreClient)
+	return err
 }
-func ProcessImageWithWatermark(imageID string, sizename string, storageClient *storage.Client, firestoreClient *firestore.Client) error {
-	ctx := context.Background()
-	bucketName := "halogen-device-438608-v9.appspot.com"
-	// Step 1: Find the small resized image path from Firestore
-	docRef := firestoreClient.Collection("posts").Doc(imageID).Collection("resized_images").Doc(sizename)
-	doc, err := docRef.Get(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to get %s resized image from Firestore: %v", sizename, err)
-	}
-	ImagePath, ok := doc.Data()["Path"].(string)
-	if !ok {
-		return fmt.Errorf("failed to find the 'Path' field in the Firestore document")
-	}
-	reader, err := storageClient.Bucket(bucketName).Object(ImagePath).NewReader(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to download small image from storage: %v", err)
+
+// WatermarkVariant produces watermarked_{sizename} in a single pass. The
+// source is the stored resize when there is one; otherwise the original is
+// resized, that resize stored, and the in-memory result watermarked. The
+// size "original" watermarks the original at full resolution.
+func WatermarkVariant(ctx context.Context, imageID string, sizename string, storageClient *storage.Client, firestoreClient *firestore.Client) (result *VariantResult, err error) {
+	ctx, span := startImageSpan(ctx, "WatermarkVariant", imageID, sizename)
+	defer func() { endSpan(span, err) }()
+	if _, _, err := ParseVariant(VariantName(sizename, true)); err != nil {
+		return nil, err
 	}
-	defer reader.Close()
+	start := time.Now()
+	defer beginJob()()
 
-	img, _, err := image.Decode(reader)
+	img, result, err := watermarkSource(ctx, imageID, sizename, storageClient, firestoreClient)
 	if err != nil {
-		return fmt.Errorf("failed to decode %s image: %v", img, err)
+		return nil, err
 	}
-
-	// Step 3: Download the watermark image from Firebase Storage
-	watermark, err := imaging.Open("Icares_Logo.png")
+	watermark, err := loadWatermark()
 	if err != nil {
-		return fmt.Errorf("watermark loading failed: %v", err)
+		return nil, fmt.Errorf("watermark loading failed: %v", err)
 	}
 
-	// Step 4: Apply the watermark on the small image
+	_, stage := startStage(ctx, "watermark")
 	imgWithWatermark := AddWatermark(img, watermark)
+	stage.end(nil)
 
-	// Step 5: Save the watermarked image back to Firebase Storage
-	watermarkedPath := fmt.Sprintf("watermarked/%s_watermarked_%s.jpg", sizename, imageID)
-	writer := storageClient.Bucket(bucketName).Object(watermarkedPath).NewWriter(ctx)
-	defer writer.Close()
+	if result.Path, err = storeWatermarked(ctx, storageClient, firestoreClient, imageID, sizename, imgWithWatermark); err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "watermarked image saved", "image_id", imageID, "size", sizename, "path", result.Path, "source", result.Source, "created_resize", result.CreatedResize, "duration", time.Since(start))
+	return result, nil
+}
 
-	writer.ContentType = "image/jpeg"
-	if err := jpeg.Encode(writer, imgWithWatermark, &jpeg.Options{Quality: 90}); err != nil {
-		return fmt.Errorf("failed to encode and upload watermarked image: %v", err)
+// watermarkSource returns the image to watermark for sizename, resizing and
+// storing it when the resize is missing. The result names the source.
+func watermarkSource(ctx context.Context, imageID, sizename string, storageClient *storage.Client, firestoreClient *firestore.Client) (image.Image, *VariantResult, error) {
+	result := &VariantResult{Name: VariantName(sizename, true)}
+	if sizename == OriginalSize {
+		result.Source = originalObjectPath(imageID)
+		img, err := downloadOriginal(ctx, storageClient, imageID)
+		return img, result, err
 	}
-	waterPath := fmt.Sprintf("watermarked_%s", sizename)
-	waterPathImage := fmt.Sprintf("Watermarked %s image", sizename)
-	// Step 6: Save the watermarked image path to Firestore
-	err = SaveWatermarkedImageDetailsToFirestore(firestoreClient, imageID, waterPath, waterPathImage, watermarkedPath)
-	if err != nil {
-		return fmt.Errorf("failed to save watermarked image details to Firestore: %v", err)
+
+	doc, err := firestoreClient.Collection("posts").Doc(imageID).Collection("resized_images").Doc(sizename).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, nil, backendError(err, "failed to get %s resized image from Firestore", sizename)
+	}
+	if err == nil {
+		path, ok := doc.Data()["Path"].(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("failed to find the 'Path' field in the Firestore document")
+		}
+		result.Source = path
+		img, err := downloadImage(ctx, storageClient, Settings.BucketName, path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to download %s image: %w", sizename, err)
+		}
+		return img, result, nil
 	}
 
-	fmt.Printf("Watermarked %s image saved successfully: %s\n", sizename, watermarkedPath)
-	return nil
+	resizeFunc, err := resizeFuncForSize(sizename)
+	if err != nil {
+		return nil, nil, err
+	}
+	source, _, err := resizeSource(ctx, storageClient, firestoreClient, imageID, Settings.SizePresets[strings.ToLower(sizename)], sizename)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, stage := startStage(ctx, "resize")
+	img := resizeFunc(source)
+	stage.end(nil)
+	if result.Source, err = storeResized(ctx, storageClient, firestoreClient, imageID, sizename, img); err != nil {
+		return nil, nil, err
+	}
+	result.CreatedResize = true
+	return img, result, nil
+}
 
+// storeWatermarked uploads a watermarked image and records it under watermarks.
+func storeWatermarked(ctx context.Context, storageClient *storage.Client, firestoreClient *firestore.Client, imageID, sizename string, img image.Image) (string, error) {
+	path := watermarkedObjectPath(imageID, sizename)
+	if err := uploadJPEG(ctx, storageClient, Settings.BucketName, path, img, jpegSettingsFor(sizename)); err != nil {
+		return "", fmt.Errorf("failed to encode and upload watermarked image: %w", err)
+	}
+	err := SaveWatermarkedImageDetailsToFirestore(ctx, firestoreClient, imageID, VariantName(sizename, true), fmt.Sprintf("Watermarked %s image", sizename), path)
+	if err != nil {
+		return "", fmt.Errorf("failed to save watermarked image details to Firestore: %w", err)
+	}
+	return path, nil
 }
 
-func GetWaterImageDetailFromFirestore(client *firestore.Client, parentID string, sizename string) (map[string]interface{}, error) {
-	ctx := context.Background()
+func GetWaterImageDetailFromFirestore(ctx context.Context, client *firestore.Client, parentID string, sizename string) (map[string]interface{}, error) {
 	docname := fmt.Sprintf("watermarked_%s", sizename)
 	// Reference the Firestore document for the small image
 	docRef := client.Collection("posts").Doc(parentID).Collection("watermarks").Doc(docname)
@@ -401,48 +409,116 @@ func GetWaterImageDetailFromFirestore(client *firestore.Client, parentID string,
 	doc, err := docRef.Get(ctx)
 	if err != nil {
 
-		return nil, fmt.Errorf("failed to get %s watermark image details from Firestore: %v", sizename, err)
+		return nil, backendError(err, "failed to get %s watermark image details from Firestore", sizename)
 	}
 
 	// Extract the document data
 	imageDetails := doc.Data()
 
-	log.Printf("%s Watermark image details retrieved from Firestore: parentID = %s\n", sizename, parentID)
+	slog.DebugContext(ctx, "watermark image details retrieved from Firestore", "image_id", parentID, "size", sizename)
 	return imageDetails, nil
 }
-func UploadWatermarkImageHandler(base64ImageData string, ImageName string, StorageClient *storage.Client, firestoreClient *firestore.Client) error {
-	if strings.HasPrefix(base64ImageData, "data:image/") {
-		commaIndex := strings.Index(base64ImageData, ",")
-		if commaIndex != -1 {
-			base64ImageData = base64ImageData[commaIndex+1:]
-		}
-	}
 
-	// Decode the Base64 string into image bytes
-	imageData, err := base64.StdEncoding.DecodeString(base64ImageData)
+// WatermarkUpload describes a stored watermark asset. Watermarks keep their
+// transparency, so they are stored as PNG whatever format was uploaded.
+// Other formats are converted, often growing, so savings are only reported
+// for PNG uploads.
+type WatermarkUpload struct {
+	Path          string   `json:"path"`
+	Format        string   `json:"format"`
+	Converted     bool     `json:"converted"`
+	OriginalBytes int      `json:"originalBytes"`
+	Bytes         int      `json:"bytes"`
+	SavedBytes    *int     `json:"savedBytes,omitempty"`
+	SavedPercent  *float64 `json:"savedPercent,omitempty"`
+}
+
+// UploadWatermarkImageHandler stores a watermark the way v1 always has: at
+// the object path ImageName, recorded in the posts collection under that
+// name.
+func UploadWatermarkImageHandler(ctx context.Context, base64ImageData string, ImageName string, StorageClient *storage.Client, firestoreClient *firestore.Client) (result *WatermarkUpload, err error) {
+	defer beginJob()()
+	ctx, span := startImageSpan(ctx, "UploadWatermarkImageHandler", ImageName, "original")
+	defer func() { endSpan(span, err) }()
+
+	result, bounds, err := storeWatermark(ctx, base64ImageData, ImageName, ImageName, StorageClient)
+	if err != nil {
+		return nil, err
+	}
+	description := "Watermark Image uploaded successfully!!!"
+	err = SaveUploadedImageDetailsToFirestore(ctx, firestoreClient, ImageName, description, result.Path, bounds.Dx(), bounds.Dy())
 	if err != nil {
-		return fmt.Errorf("unable to decode Base64 string: %v", err)
+		return nil, fmt.Errorf("error saving watermark image details to Firestore: %w", err)
 	}
+	return result, nil
+}
 
-	// Create a reader from the decoded bytes
-	imageReader := strings.NewReader(string(imageData))
+// watermarkAssetsCollection holds watermark documents, apart from posts so a
+// watermark name can never address an uploaded image.
+const watermarkAssetsCollection = "watermark_assets"
 
-	// Decode the image to check if it's a valid image
-	img, format, err := image.Decode(imageReader)
+// watermarkNamePattern restricts watermark names to one path segment.
+var watermarkNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+func watermarkAssetPath(name string) string {
+	return fmt.Sprintf("watermarks/%s.png", name)
+}
+
+// UploadWatermark stores a named watermark at watermarks/{name}.png and
+// records it in the watermark_assets collection.
+func UploadWatermark(ctx context.Context, base64ImageData string, name string, storageClient *storage.Client, firestoreClient *firestore.Client) (result *WatermarkUpload, err error) {
+	defer beginJob()()
+	ctx, span := startImageSpan(ctx, "UploadWatermark", name, "original")
+	defer func() { endSpan(span, err) }()
+	if !watermarkNamePattern.MatchString(name) {
+		return nil, newError(ErrInvalidInput, nil, "watermark name must be 1 to 64 letters, digits, dashes or underscores, got %q", name)
+	}
+
+	result, _, err = storeWatermark(ctx, base64ImageData, name, watermarkAssetPath(name), storageClient)
+	if err != nil {
+		return nil, err
+	}
+	_, err = firestoreClient.Collection(watermarkAssetsCollection).Doc(name).Set(ctx, map[string]interface{}{
+		"ID":          name,
+		"Description": "Watermark image",
+		"Filepath":    result.Path,
+	})
 	if err != nil {
-		return fmt.Errorf("invalid image format: %v", err)
+		return nil, backendError(err, "failed to save watermark image details to Firestore")
 	}
+	return result, nil
+}
 
-	log.Printf("Image decoded successfully: format = %s\n", format)
-	Filepath, err := UploadImageToFirebase(StorageClient, ImageName, img)
+// storeWatermark checks and decodes an uploaded watermark and stores it at
+// objectPath as an optimized PNG, returning the decoded image's bounds.
+func storeWatermark(ctx context.Context, base64ImageData, name, objectPath string, storageClient *storage.Client) (*WatermarkUpload, image.Rectangle, error) {
+	// Check limits and decode the image to check if it's a valid image
+	imageData, err := decodeBase64Payload(base64ImageData)
 	if err != nil {
-		return fmt.Errorf("error uploading image: %v", err)
+		return nil, image.Rectangle{}, err
 	}
-	description := "Watermark Image uploaded successfully!!!"
-	err = SaveUploadedImageDetailsToFirestore(firestoreClient, ImageName, description, Filepath)
+	img, format, err := decodeImageData(imageData)
 	if err != nil {
-		return fmt.Errorf("error saving watermark image details to Firestore: %v", err)
+		return nil, image.Rectangle{}, err
 	}
-	return nil
 
+	slog.InfoContext(ctx, "watermark image decoded", "image_id", name, "format", format)
+	size, err := uploadPNG(ctx, storageClient, Settings.BucketName, objectPath, img, imageData)
+	if err != nil {
+		return nil, image.Rectangle{}, fmt.Errorf("error uploading image: %w", err)
+	}
+	result := &WatermarkUpload{
+		Path:          objectPath,
+		Format:        format,
+		Converted:     format != "png",
+		OriginalBytes: len(imageData),
+		Bytes:         size,
+	}
+	if !result.Converted {
+		saved := len(imageData) - size
+		percent := math.Round(float64(saved)/float64(len(imageData))*1000) / 10
+		result.SavedBytes, result.SavedPercent = &saved, &percent
+	}
+	slog.InfoContext(ctx, "watermark image stored", "image_id", name, "format", format, "converted", result.Converted, "original_bytes", result.OriginalBytes, "bytes", result.Bytes)
+	return result, img.Bounds(), nil
 }
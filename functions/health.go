@@ -0,0 +1,28 @@
+package functions
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// CheckStorage verifies the image bucket is reachable.
+func CheckStorage(ctx context.Context, client *storage.Client) error {
+	bucketName := Settings.BucketName
+	if _, err := client.Bucket(bucketName).Attrs(ctx); err != nil {
+		return backendError(err, "storage bucket %s is unreachable", bucketName)
+	}
+	return nil
+}
+
+// CheckFirestore verifies the metadata collection can be queried.
+func CheckFirestore(ctx context.Context, client *firestore.Client) error {
+	iter := client.Collection("posts").Limit(1).Documents(ctx)
+	defer iter.Stop()
+	if _, err := iter.Next(); err != nil && err != iterator.Done {
+		return backendError(err, "Firestore is unreachable")
+	}
+	return nil
+}
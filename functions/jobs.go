@@ -0,0 +1,122 @@
+package functions
+
+import (
+	"Project/metrics"
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+// jobs tracks image processing work, including queued background jobs, so
+// shutdown can wait for it to finish.
+var jobs sync.WaitGroup
+
+// beginJob registers a running job; call the returned function when it ends.
+func beginJob() func() {
+	jobs.Add(1)
+	return jobs.Done
+}
+
+// background queues work that outlives its request. A fixed number of
+// workers run it, so a burst of uploads cannot decode an unbounded number of
+// originals at once.
+var background struct {
+	mu     sync.Mutex
+	queue  chan backgroundJob // nil before StartBackgroundWorkers and after WaitForJobs
+	cancel context.CancelFunc
+}
+
+type backgroundJob struct {
+	ctx   context.Context
+	name  string
+	run   func(ctx context.Context) error
+	attrs []any
+}
+
+// StartBackgroundWorkers starts Settings.BackgroundWorkers goroutines running
+// the jobs queued by runInBackground, of which at most Settings.
+// BackgroundQueueSize may wait. WaitForJobs stops them.
+func StartBackgroundWorkers() {
+	background.mu.Lock()
+	defer background.mu.Unlock()
+	var ctx context.Context
+	ctx, background.cancel = context.WithCancel(context.Background())
+	background.queue = make(chan backgroundJob, Settings.BackgroundQueueSize)
+	for range Settings.BackgroundWorkers {
+		go runBackgroundJobs(ctx, background.queue)
+	}
+}
+
+func runBackgroundJobs(ctx context.Context, queue <-chan backgroundJob) {
+	for job := range queue {
+		metrics.JobQueueDepth.Dec()
+		job.execute(ctx)
+	}
+}
+
+// runInBackground queues run and returns without waiting for it. run gets the
+// values of ctx, whose request is over by then, and is cancelled when
+// shutdown runs out of time instead. Failures are logged with attrs and
+// counted. It returns false, dropping the job, when the queue is full or the
+// service is shutting down.
+func runInBackground(ctx context.Context, name string, run func(ctx context.Context) error, attrs ...any) bool {
+	job := backgroundJob{ctx: context.WithoutCancel(ctx), name: name, run: run, attrs: attrs}
+	background.mu.Lock()
+	defer background.mu.Unlock()
+	reason := "shutting down"
+	if background.queue != nil {
+		// Register the job before returning so shutdown waits for it
+		jobs.Add(1)
+		select {
+		case background.queue <- job:
+			metrics.JobQueueDepth.Inc()
+			return true
+		default:
+			jobs.Done()
+			reason = "queue full"
+		}
+	}
+	metrics.BackgroundJobsDropped.WithLabelValues(name).Inc()
+	slog.WarnContext(ctx, "background job dropped", slices.Concat([]any{"job", name, "reason", reason}, attrs)...)
+	return false
+}
+
+func (job backgroundJob) execute(workerCtx context.Context) {
+	defer jobs.Done()
+	ctx, cancel := context.WithCancel(job.ctx)
+	defer cancel()
+	defer context.AfterFunc(workerCtx, cancel)()
+	if err := job.run(ctx); err != nil {
+		metrics.Errors.WithLabelValues(ErrorType(err)).Inc()
+		slog.ErrorContext(ctx, "background job failed", slices.Concat([]any{"job", job.name}, job.attrs, []any{"error", err})...)
+	}
+}
+
+// WaitForJobs stops accepting background jobs and blocks until every running
+// and queued job has finished or ctx is done, in which case the background
+// jobs still running are cancelled.
+func WaitForJobs(ctx context.Context) error {
+	background.mu.Lock()
+	if background.queue != nil {
+		close(background.queue)
+		background.queue = nil
+	}
+	cancel := background.cancel
+	background.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		if cancel != nil {
+			cancel()
+		}
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,73 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestRunInBackground ends with a shutdown that times out, after which the
+// job WaitGroup must not be reused, so it covers the pool in one test.
+func TestRunInBackground(t *testing.T) {
+	defer func(workers, queueSize int) {
+		Settings.BackgroundWorkers, Settings.BackgroundQueueSize = workers, queueSize
+	}(Settings.BackgroundWorkers, Settings.BackgroundQueueSize)
+	Settings.BackgroundWorkers, Settings.BackgroundQueueSize = 1, 1
+
+	if runInBackground(context.Background(), "test", func(context.Context) error { return nil }) {
+		t.Fatal("job queued before the workers started")
+	}
+	StartBackgroundWorkers()
+
+	// The job keeps the request's values but not its cancellation
+	type key struct{}
+	requestCtx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "request"))
+	cancel()
+	result := make(chan error, 1)
+	runInBackground(requestCtx, "test", func(ctx context.Context) error {
+		if ctx.Value(key{}) != "request" {
+			result <- errors.New("request values lost")
+		} else {
+			result <- ctx.Err()
+		}
+		return nil
+	})
+	if err := <-result; err != nil {
+		t.Fatalf("job context: %v", err)
+	}
+
+	started, ran := make(chan struct{}), make(chan struct{}, 1)
+	if !runInBackground(context.Background(), "test", func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}) {
+		t.Fatal("blocking job dropped")
+	}
+	<-started
+	if !runInBackground(context.Background(), "test", func(ctx context.Context) error {
+		ran <- struct{}{}
+		return nil
+	}) {
+		t.Fatal("job dropped while the queue had room")
+	}
+	if runInBackground(context.Background(), "test", func(context.Context) error { return nil }) {
+		t.Fatal("job queued beyond the queue size")
+	}
+
+	ctx, cancelWait := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelWait()
+	if err := WaitForJobs(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitForJobs: got %v, want the deadline to expire on the blocking job", err)
+	}
+	// Shutdown cancelled the blocking job, so the queued one gets its turn
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("queued job never ran")
+	}
+	if runInBackground(context.Background(), "test", func(context.Context) error { return nil }) {
+		t.Fatal("job queued after shutdown")
+	}
+}
@@ -0,0 +1,142 @@
+package functions
+
+import (
+	"Project/metrics"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+var (
+	// ErrImageTooLarge is returned when an upload exceeds the byte, dimension or pixel limits.
+	ErrImageTooLarge = errors.New("image too large")
+	// ErrUnsupportedFormat is returned when the uploaded bytes are not an allowed image format.
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+)
+
+// ImageLimits bounds what an upload may contain before it is fully decoded.
+type ImageLimits struct {
+	MaxBytes       int64
+	MaxWidth       int
+	MaxHeight      int
+	MaxPixels      int64
+	AllowedFormats []string // MIME types, e.g. "image/png"
+}
+
+// DefaultLimits are the limits used unless the configuration overrides them.
+var DefaultLimits = ImageLimits{
+	MaxBytes:       20 << 20,
+	MaxWidth:       10000,
+	MaxHeight:      10000,
+	MaxPixels:      50_000_000,
+	AllowedFormats: []string{"image/jpeg", "image/png", "image/gif", "image/bmp", "image/tiff", "image/webp"},
+}
+
+// DecodeBase64Image strips an optional data URL prefix, checks the payload
+// against Settings.Limits and decodes it.
+func DecodeBase64Image(base64ImageData string) (image.Image, string, error) {
+	imageData, err := decodeBase64Payload(base64ImageData)
+	if err != nil {
+		return nil, "", err
+	}
+	return decodeImageData(imageData)
+}
+
+// decodeBase64Payload strips an optional data URL prefix and returns the
+// image bytes once they pass the Settings.Limits checks.
+func decodeBase64Payload(base64ImageData string) ([]byte, error) {
+	if strings.HasPrefix(base64ImageData, "data:image/") {
+		commaIndex := strings.Index(base64ImageData, ",")
+		if commaIndex != -1 {
+			base64ImageData = base64ImageData[commaIndex+1:]
+		}
+	}
+
+	// Reject before allocating the decoded buffer
+	if Settings.Limits.MaxBytes > 0 && int64(base64.StdEncoding.DecodedLen(len(base64ImageData))) > Settings.Limits.MaxBytes+2 {
+		return nil, newError(ErrImageTooLarge, nil, "payload exceeds %d bytes", Settings.Limits.MaxBytes)
+	}
+
+	// Decode the Base64 string into image bytes
+	imageData, err := base64.StdEncoding.DecodeString(base64ImageData)
+	if err != nil {
+		return nil, newError(ErrInvalidInput, err, "unable to decode Base64 string")
+	}
+
+	if err := CheckImageData(imageData, Settings.Limits); err != nil {
+		return nil, err
+	}
+
+	metrics.ImageBytes.WithLabelValues("upload").Add(float64(len(imageData)))
+	return imageData, nil
+}
+
+// decodeImageData decodes checked image bytes, recording decode metrics.
+func decodeImageData(imageData []byte) (image.Image, string, error) {
+	// Decode the image to check if it's a valid image
+	start := time.Now()
+	img, format, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		return nil, "", decodeError(err, "unable to decode image")
+	}
+	metrics.ObserveStage("decode", start)
+	metrics.ObserveDimensions(img.Bounds().Dx(), img.Bounds().Dy())
+	return img, format, nil
+}
+
+// CheckImageData sniffs the magic bytes and reads only the image header to
+// enforce limits, so oversized images are rejected before a full decode.
+func CheckImageData(data []byte, limits ImageLimits) error {
+	if limits.MaxBytes > 0 && int64(len(data)) > limits.MaxBytes {
+		return newError(ErrImageTooLarge, nil, "payload is %d bytes, limit is %d", len(data), limits.MaxBytes)
+	}
+
+	mtype := mimetype.Detect(data)
+	if !formatAllowed(mtype, limits.AllowedFormats) {
+		return newError(ErrUnsupportedFormat, nil, "image format %s is not allowed", mtype.String())
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return decodeError(err, "unable to read image header")
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return newError(ErrInvalidInput, nil, "invalid image dimensions %dx%d", cfg.Width, cfg.Height)
+	}
+	if limits.MaxWidth > 0 && cfg.Width > limits.MaxWidth {
+		return newError(ErrImageTooLarge, nil, "width %d exceeds %d", cfg.Width, limits.MaxWidth)
+	}
+	if limits.MaxHeight > 0 && cfg.Height > limits.MaxHeight {
+		return newError(ErrImageTooLarge, nil, "height %d exceeds %d", cfg.Height, limits.MaxHeight)
+	}
+	if pixels := int64(cfg.Width) * int64(cfg.Height); limits.MaxPixels > 0 && pixels > limits.MaxPixels {
+		return newError(ErrImageTooLarge, nil, "%d pixels exceeds %d", pixels, limits.MaxPixels)
+	}
+	return nil
+}
+
+// decodeError reports data no decoder recognizes as an unsupported format,
+// and data a decoder recognized but could not read as corrupt input.
+func decodeError(err error, detail string) error {
+	if errors.Is(err, image.ErrFormat) {
+		return newError(ErrUnsupportedFormat, err, "unsupported image format")
+	}
+	return newError(ErrInvalidInput, err, "%s, the file is corrupt", detail)
+}
+
+func formatAllowed(mtype *mimetype.MIME, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, format := range allowed {
+		if mtype.Is(strings.TrimSpace(format)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package functions
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pngDeclaring returns a PNG whose IHDR declares width x height but whose
+// pixel data is that of a 1x1 image, so it only decodes at that size.
+func pngDeclaring(t *testing.T, width, height uint32) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// The IHDR chunk follows the 8 byte signature: length, type, data, CRC
+	ihdr := data[8+8 : 8+8+13]
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	binary.BigEndian.PutUint32(data[8+8+13:], crc32.ChecksumIEEE(data[8+4:8+8+13]))
+	return data
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCheckImageData(t *testing.T) {
+	sample := readFixture(t, "sample.png") // 24x16
+	limits := ImageLimits{MaxBytes: 1 << 20, MaxWidth: 100, MaxHeight: 50, MaxPixels: 3000, AllowedFormats: []string{"image/png"}}
+	tests := []struct {
+		name   string
+		data   []byte
+		limits func(l *ImageLimits)
+		want   error
+	}{
+		{"within limits", sample, nil, nil},
+		{"no limits", pngDeclaring(t, 100000, 100000), func(l *ImageLimits) { *l = ImageLimits{} }, nil},
+		{"too many bytes", sample, func(l *ImageLimits) { l.MaxBytes = int64(len(sample)) - 1 }, ErrImageTooLarge},
+		{"exactly the byte limit", sample, func(l *ImageLimits) { l.MaxBytes = int64(len(sample)) }, nil},
+		{"too wide", pngDeclaring(t, 101, 10), nil, ErrImageTooLarge},
+		{"too tall", pngDeclaring(t, 10, 51), nil, ErrImageTooLarge},
+		{"too many pixels", pngDeclaring(t, 100, 31), nil, ErrImageTooLarge},
+		{"exactly the pixel limit", pngDeclaring(t, 100, 30), nil, nil},
+		{"huge declared dimensions", pngDeclaring(t, 100000, 100000), nil, ErrImageTooLarge},
+		{"zero width", pngDeclaring(t, 0, 10), nil, ErrInvalidInput},
+		{"format not allowed", readFixture(t, "sample.gif"), nil, ErrUnsupportedFormat},
+		{"not an image", []byte("just some text, not an image at all"), nil, ErrUnsupportedFormat},
+		{"no decoder", []byte("<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>"), func(l *ImageLimits) { l.AllowedFormats = nil }, ErrUnsupportedFormat},
+		{"corrupt header", sample[:20], nil, ErrInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := limits
+			if tt.limits != nil {
+				tt.limits(&l)
+			}
+			err := CheckImageData(tt.data, l)
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeBase64ImageLimits(t *testing.T) {
+	defer func(limits ImageLimits) { Settings.Limits = limits }(Settings.Limits)
+	Settings.Limits = ImageLimits{MaxBytes: 1 << 10, MaxWidth: 1000, MaxHeight: 1000, MaxPixels: 1_000_000, AllowedFormats: []string{"image/png"}}
+
+	sample := readFixture(t, "sample.png")
+	encode := base64.StdEncoding.EncodeToString
+	tests := []struct {
+		name    string
+		payload string
+		want    error
+	}{
+		{"valid", encode(sample), nil},
+		{"valid data URL", "data:image/png;base64," + encode(sample), nil},
+		{"invalid base64", "not base64!", ErrInvalidInput},
+		// Rejected from the encoded length, before decoding the base64
+		{"payload over the byte limit", encode(make([]byte, 2<<10)), ErrImageTooLarge},
+		// Its pixels would not decode, so this proves the header is checked first
+		{"huge declared dimensions", encode(pngDeclaring(t, 100000, 100000)), ErrImageTooLarge},
+		{"valid header, truncated pixels", encode(sample[:len(sample)*2/3]), ErrInvalidInput},
+		{"format not allowed", encode(readFixture(t, "sample.webp")), ErrUnsupportedFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := DecodeBase64Image(tt.payload)
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package functions
+
+import (
+	"Project/jpegenc"
+	"Project/metrics"
+	"Project/pngopt"
+	"Project/tracing"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// downloadImage reads an object from the bucket and decodes it, recording
+// download and decode timings.
+func downloadImage(ctx context.Context, client *storage.Client, bucketName, objectPath string) (image.Image, error) {
+	data, err := downloadObject(ctx, client, bucketName, objectPath)
+	if err != nil {
+		return nil, err
+	}
+
+	_, stage := startStage(ctx, "decode")
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		err = fmt.Errorf("failed to decode %s: %v", objectPath, err)
+		stage.end(err)
+		return nil, err
+	}
+	stage.span.SetAttributes(
+		attribute.Int("image.width", img.Bounds().Dx()),
+		attribute.Int("image.height", img.Bounds().Dy()),
+	)
+	stage.end(nil)
+	metrics.ObserveDimensions(img.Bounds().Dx(), img.Bounds().Dy())
+	return img, nil
+}
+
+func downloadObject(ctx context.Context, client *storage.Client, bucketName, objectPath string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, Settings.StorageTimeout)
+	defer cancel()
+	ctx, stage := startStage(ctx, "download")
+	stage.span.SetAttributes(attribute.String("storage.object", objectPath))
+	reader, err := client.Bucket(bucketName).Object(objectPath).NewReader(ctx)
+	if err != nil {
+		err = backendError(err, "failed to get %s from storage", objectPath)
+		stage.end(err)
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(metrics.CountingReader{Reader: reader})
+	if err != nil {
+		err = backendError(err, "failed to read %s from storage", objectPath)
+	}
+	stage.end(err)
+	return data, err
+}
+
+// uploadJPEG encodes img as JPEG with the given settings and writes it to
+// the bucket, recording encode and upload timings.
+func uploadJPEG(ctx context.Context, client *storage.Client, bucketName, objectPath string, img image.Image, settings JPEGSettings) error {
+	_, stage := startStage(ctx, "encode")
+	var buf bytes.Buffer
+	var err error
+	if settings.MaxBytes > 0 {
+		var quality int
+		quality, err = jpegenc.EncodeWithin(&buf, img, &settings.Options, settings.MaxBytes, jpegMinQuality)
+		stage.span.SetAttributes(attribute.Int("jpeg.quality", quality))
+	} else {
+		err = jpegenc.Encode(&buf, img, &settings.Options)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to encode %s: %v", objectPath, err)
+		stage.end(err)
+		return err
+	}
+	stage.end(nil)
+
+	return uploadObject(ctx, client, bucketName, objectPath, "image/jpeg", buf.Bytes())
+}
+
+// uploadPNG writes img to the bucket as an optimized PNG, recording encode
+// and upload timings, and returns the stored size. original is the uploaded
+// file, which is stored stripped of metadata when that is smallest.
+func uploadPNG(ctx context.Context, client *storage.Client, bucketName, objectPath string, img image.Image, original []byte) (int, error) {
+	_, stage := startStage(ctx, "encode")
+	data, err := pngopt.Optimize(img, original)
+	if err != nil {
+		err = fmt.Errorf("failed to encode %s: %v", objectPath, err)
+		stage.end(err)
+		return 0, err
+	}
+	stage.end(nil)
+
+	return len(data), uploadObject(ctx, client, bucketName, objectPath, "image/png", data)
+}
+
+func uploadObject(ctx context.Context, client *storage.Client, bucketName, objectPath, contentType string, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, Settings.StorageTimeout)
+	defer cancel()
+	ctx, stage := startStage(ctx, "upload")
+	stage.span.SetAttributes(
+		attribute.String("storage.object", objectPath),
+		attribute.Int("storage.bytes", len(data)),
+	)
+	writer := client.Bucket(bucketName).Object(objectPath).NewWriter(ctx)
+	writer.ContentType = contentType
+	counter := metrics.CountingWriter{Writer: writer}
+	if _, err := counter.Write(data); err != nil {
+		writer.Close()
+		err = backendError(err, "failed to write %s to storage", objectPath)
+		stage.end(err)
+		return err
+	}
+	err := writer.Close()
+	if err != nil {
+		err = backendError(err, "failed to write %s to storage", objectPath)
+	}
+	stage.end(err)
+	return err
+}
+
+// pipelineStage is a span plus a duration metric for one step of the image
+// pipeline.
+type pipelineStage struct {
+	name  string
+	start time.Time
+	span  trace.Span
+}
+
+func startStage(ctx context.Context, name string) (context.Context, *pipelineStage) {
+	ctx, span := tracing.Start(ctx, name)
+	return ctx, &pipelineStage{name: name, start: time.Now(), span: span}
+}
+
+// end records the stage duration and finishes the span, marking it failed
+// when err is non-nil.
+func (s *pipelineStage) end(err error) {
+	metrics.ObserveStage(s.name, s.start)
+	endSpan(s.span, err)
+}
+
+// startImageSpan starts the span for a whole operation on one image.
+func startImageSpan(ctx context.Context, name, imageID, sizename string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, name, trace.WithAttributes(
+		attribute.String("image.id", imageID),
+		attribute.String("image.size", sizename),
+	))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,207 @@
+package functions
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"github.com/gen2brain/avif"
+	"github.com/gen2brain/webp"
+)
+
+// renditionsField is the variant document field mapping a format name to
+// the path of the variant stored in that format.
+const renditionsField = "Renditions"
+
+// renditionFormat is a format variants can also be served in. The encoders
+// run libwebp and libavif as WebAssembly, so they need no cgo.
+type renditionFormat struct {
+	mime   string
+	encode func(w io.Writer, img image.Image) error
+}
+
+var renditionFormats = map[string]renditionFormat{
+	"avif": {mime: "image/avif", encode: func(w io.Writer, img image.Image) error { return avif.Encode(w, img) }},
+	"webp": {mime: "image/webp", encode: func(w io.Writer, img image.Image) error { return webp.Encode(w, img) }},
+}
+
+// NegotiateFormat returns the entry of Settings.RenditionFormats the Accept
+// header prefers, or "" to serve JPEG. Only formats the client names
+// explicitly count; wildcards such as image/* are answered with JPEG.
+func NegotiateFormat(accept string) string {
+	best, bestQuality := "", 0.0
+	for _, format := range Settings.RenditionFormats {
+		mime := renditionFormats[format].mime
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, _ := strings.Cut(mediaRange, ";")
+			if !strings.EqualFold(strings.TrimSpace(mediaType), mime) {
+				continue
+			}
+			quality := acceptQuality(params)
+			// Settings order breaks ties, so only a strictly better q wins
+			if quality > bestQuality {
+				best, bestQuality = format, quality
+			}
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q parameter of a media range, 1 when absent.
+func acceptQuality(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(key, "q") {
+			quality, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return 0
+			}
+			return quality
+		}
+	}
+	return 1
+}
+
+// RenditionPath returns the path of a variant stored in format. details is
+// the variant document as returned by GetVariantDetailsFromFirestore.
+// attempted is false when no rendition was made yet, and path is "" when
+// none will be, because the variant is larger than Settings.
+// RenditionMaxPixels or failed to encode. Regenerating the variant replaces
+// the document, so stale renditions are made again.
+func RenditionPath(details map[string]interface{}, format string) (path string, attempted bool) {
+	renditions, _ := details[renditionsField].(map[string]interface{})
+	path, attempted = renditions[format].(string)
+	return path, attempted
+}
+
+// renditionsQueued holds the renditions waiting for or being encoded, so
+// requests arriving meanwhile do not queue them again.
+var renditionsQueued sync.Map
+
+// GenerateRenditionInBackground queues storing a variant in format without
+// waiting for it; callers serve the JPEG meanwhile. A rendition already
+// queued is not queued again. Failures are logged and counted; storage
+// errors are retried on a later request, encoder failures are not.
+func GenerateRenditionInBackground(ctx context.Context, imageID, variant string, details map[string]interface{}, format string, storageClient *storage.Client, firestoreClient *firestore.Client) {
+	key := imageID + "/" + variant + "/" + format
+	if _, queued := renditionsQueued.LoadOrStore(key, struct{}{}); queued {
+		return
+	}
+	queued := runInBackground(ctx, "rendition", func(ctx context.Context) error {
+		defer renditionsQueued.Delete(key)
+		return createRendition(ctx, imageID, variant, details, format, storageClient, firestoreClient)
+	}, "image_id", imageID, "variant", variant, "format", format)
+	if !queued {
+		renditionsQueued.Delete(key)
+	}
+}
+
+// createRendition encodes the stored JPEG of a variant in format and records
+// the result on the variant document. Variants over Settings.
+// RenditionMaxPixels, and those the encoder fails on, are recorded with an
+// empty path instead, so they are not downloaded again.
+func createRendition(ctx context.Context, imageID, variant string, details map[string]interface{}, format string, storageClient *storage.Client, firestoreClient *firestore.Client) (err error) {
+	ctx, span := startImageSpan(ctx, "CreateRendition", imageID, variant)
+	defer func() { endSpan(span, err) }()
+
+	rendition, ok := renditionFormats[format]
+	if !ok {
+		return newError(ErrInvalidInput, nil, "unknown rendition format %s", format)
+	}
+	sizename, watermarked, err := ParseVariant(variant)
+	if err != nil {
+		return err
+	}
+	jpegPath, ok := details["Path"].(string)
+	if !ok {
+		return fmt.Errorf("variant %s of %s has no path", variant, imageID)
+	}
+
+	// Resized variants record their size; others are checked once decoded
+	width, _ := details["Width"].(int64)
+	height, _ := details["Height"].(int64)
+	var renditionPath string
+	var encodeErr error
+	if !renditionTooLarge(width, height) {
+		img, err := downloadImage(ctx, storageClient, Settings.BucketName, jpegPath)
+		if err != nil {
+			return err
+		}
+		width, height = int64(img.Bounds().Dx()), int64(img.Bounds().Dy())
+		if !renditionTooLarge(width, height) {
+			_, stage := startStage(ctx, "encode")
+			var buf bytes.Buffer
+			encodeErr = encodeRendition(rendition, &buf, img)
+			stage.end(encodeErr)
+			if encodeErr == nil {
+				renditionPath = strings.TrimSuffix(jpegPath, path.Ext(jpegPath)) + "." + format
+				if err := uploadObject(ctx, storageClient, Settings.BucketName, renditionPath, rendition.mime, buf.Bytes()); err != nil {
+					return err
+				}
+				slog.InfoContext(ctx, "rendition saved", "image_id", imageID, "variant", variant, "format", format, "path", renditionPath, "bytes", buf.Len())
+			}
+		}
+	}
+	if renditionPath == "" && encodeErr == nil {
+		slog.InfoContext(ctx, "variant too large for a rendition, serving JPEG only", "image_id", imageID, "variant", variant, "format", format, "width", width, "height", height)
+	}
+
+	_, err = variantDocRef(firestoreClient, imageID, sizename, watermarked).Update(ctx, []firestore.Update{
+		{FieldPath: firestore.FieldPath{renditionsField, format}, Value: renditionPath},
+	})
+	if err != nil {
+		return backendError(err, "failed to record %s rendition of %s", format, variant)
+	}
+	if encodeErr != nil {
+		return fmt.Errorf("failed to encode %s as %s, serving JPEG only: %w", jpegPath, format, encodeErr)
+	}
+	return nil
+}
+
+func renditionTooLarge(width, height int64) bool {
+	return Settings.RenditionMaxPixels > 0 && width*height > Settings.RenditionMaxPixels
+}
+
+// CheckRenditionFormats encodes a small image in each of Settings.
+// RenditionFormats and drops the formats whose encoder fails, so they are
+// served as JPEG instead of failing on every request.
+func CheckRenditionFormats() {
+	probe := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	available := Settings.RenditionFormats[:0:0]
+	for _, format := range Settings.RenditionFormats {
+		if err := encodeRendition(renditionFormats[format], io.Discard, probe); err != nil {
+			slog.Warn("rendition encoder unavailable, serving JPEG instead", "format", format, "error", err)
+			continue
+		}
+		available = append(available, format)
+	}
+	Settings.RenditionFormats = available
+}
+
+// encodeRendition turns a panic of the WebAssembly runtime, which the
+// encoders raise when it cannot start, into an error.
+func encodeRendition(rendition renditionFormat, w io.Writer, img image.Image) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("encoder unavailable: %v", r)
+		}
+	}()
+	return rendition.encode(w, img)
+}
+
+func variantDocRef(client *firestore.Client, imageID, sizename string, watermarked bool) *firestore.DocumentRef {
+	post := client.Collection("posts").Doc(imageID)
+	if watermarked {
+		return post.Collection("watermarks").Doc(VariantName(sizename, true))
+	}
+	return post.Collection("resized_images").Doc(sizename)
+}
@@ -0,0 +1,77 @@
+package functions
+
+import (
+	"errors"
+	"image"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestRenditionPath(t *testing.T) {
+	details := map[string]interface{}{
+		"Path": "resized/image_1_small.jpg",
+		renditionsField: map[string]interface{}{
+			"webp": "resized/image_1_small.webp",
+			"avif": "",
+		},
+	}
+	tests := []struct {
+		details       map[string]interface{}
+		format        string
+		wantPath      string
+		wantAttempted bool
+	}{
+		{details, "webp", "resized/image_1_small.webp", true},
+		{details, "avif", "", true},
+		{map[string]interface{}{"Path": "resized/image_1_small.jpg"}, "webp", "", false},
+	}
+	for _, tt := range tests {
+		path, attempted := RenditionPath(tt.details, tt.format)
+		if path != tt.wantPath || attempted != tt.wantAttempted {
+			t.Errorf("RenditionPath(%v, %s) = %q, %v; want %q, %v", tt.details, tt.format, path, attempted, tt.wantPath, tt.wantAttempted)
+		}
+	}
+}
+
+func TestRenditionTooLarge(t *testing.T) {
+	defer func(limit int64) { Settings.RenditionMaxPixels = limit }(Settings.RenditionMaxPixels)
+
+	Settings.RenditionMaxPixels = 1_000_000
+	for _, tt := range []struct {
+		width, height int64
+		want          bool
+	}{
+		{1000, 1000, false},
+		{1001, 1000, true},
+		// Unrecorded dimensions are checked after decoding
+		{0, 0, false},
+	} {
+		if got := renditionTooLarge(tt.width, tt.height); got != tt.want {
+			t.Errorf("%dx%d: got %v, want %v", tt.width, tt.height, got, tt.want)
+		}
+	}
+
+	Settings.RenditionMaxPixels = 0
+	if renditionTooLarge(100_000, 100_000) {
+		t.Error("a zero limit must allow every size")
+	}
+}
+
+func TestCheckRenditionFormats(t *testing.T) {
+	defer func(formats []string) { Settings.RenditionFormats = formats }(Settings.RenditionFormats)
+	renditionFormats["failing"] = renditionFormat{encode: func(io.Writer, image.Image) error { return errors.New("no encoder") }}
+	renditionFormats["panicking"] = renditionFormat{encode: func(io.Writer, image.Image) error { panic("runtime unavailable") }}
+	renditionFormats["working"] = renditionFormat{encode: func(io.Writer, image.Image) error { return nil }}
+	defer func() {
+		delete(renditionFormats, "failing")
+		delete(renditionFormats, "panicking")
+		delete(renditionFormats, "working")
+	}()
+
+	Settings.RenditionFormats = []string{"failing", "working", "panicking"}
+	CheckRenditionFormats()
+	if want := []string{"working"}; !slices.Equal(Settings.RenditionFormats, want) {
+		t.Errorf("got %v, want %v", Settings.RenditionFormats, want)
+	}
+}
@@ -0,0 +1,128 @@
+package functions
+
+import (
+	"context"
+	"image"
+	"log/slog"
+	"slices"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// ResizeSourcePolicy decides whether resizes may start from a stored
+// variant instead of the original.
+type ResizeSourcePolicy struct {
+	FromVariants bool
+	// MinScale is how many times wider than the target a variant must be to
+	// serve as the source, so re-encoding artifacts shrink out of sight.
+	MinScale float64
+}
+
+// resizeSource returns the image to resize for a target width: the
+// narrowest stored variant that is at least MinScale times wider, or the
+// original. Variants listed in exclude, typically those being regenerated,
+// are never used. It also returns the path of the chosen image.
+func resizeSource(ctx context.Context, storageClient *storage.Client, firestoreClient *firestore.Client, imageID string, width int, exclude ...string) (image.Image, string, error) {
+	originalKnown := true
+	if Settings.ResizeSource.FromVariants {
+		path, originalWidth, err := closestVariant(ctx, firestoreClient, imageID, float64(width)*Settings.ResizeSource.MinScale, exclude)
+		if err != nil {
+			return nil, "", err
+		}
+		if path != "" {
+			slog.DebugContext(ctx, "resizing from stored variant", "image_id", imageID, "width", width, "path", path)
+			img, err := downloadImage(ctx, storageClient, Settings.BucketName, path)
+			if err != nil {
+				return nil, "", err
+			}
+			return img, path, nil
+		}
+		originalKnown = originalWidth > 0
+	}
+	img, err := downloadOriginal(ctx, storageClient, imageID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !originalKnown {
+		recordOriginalSize(ctx, firestoreClient, imageID, img.Bounds())
+	}
+	return img, originalObjectPath(imageID), nil
+}
+
+// storedVariant is a resized variant as recorded in Firestore.
+type storedVariant struct {
+	name  string
+	path  string
+	width int64
+}
+
+// closestVariant returns the path of the narrowest resized variant at least
+// minWidth wide, or "" when there is none, and the width of the original.
+// Sizes wider than the original are stored upscaled, so they are never used.
+// Images uploaded before the original's width was recorded, for which the
+// width is 0, are always resized from the original.
+func closestVariant(ctx context.Context, client *firestore.Client, imageID string, minWidth float64, exclude []string) (path string, originalWidth int64, err error) {
+	post := client.Collection("posts").Doc(imageID)
+	doc, err := post.Get(ctx)
+	if err != nil {
+		return "", 0, backendError(err, "failed to get image %s from Firestore", imageID)
+	}
+	originalWidth, _ = doc.Data()["Width"].(int64)
+	if originalWidth <= 0 {
+		return "", 0, nil
+	}
+
+	var variants []storedVariant
+	iter := post.Collection("resized_images").Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return "", 0, backendError(err, "failed to list variants of %s", imageID)
+		}
+		width, _ := doc.Data()["Width"].(int64)
+		path, _ := doc.Data()["Path"].(string)
+		variants = append(variants, storedVariant{name: doc.Ref.ID, path: path, width: width})
+	}
+	return pickVariant(variants, originalWidth, minWidth, exclude), originalWidth, nil
+}
+
+// pickVariant returns the path of the narrowest variant at least minWidth
+// and at most originalWidth wide, or "". Variants stored before their
+// dimensions were recorded are ignored.
+func pickVariant(variants []storedVariant, originalWidth int64, minWidth float64, exclude []string) string {
+	var best *storedVariant
+	for i, variant := range variants {
+		if variant.path == "" || slices.Contains(exclude, variant.name) {
+			continue
+		}
+		if float64(variant.width) < minWidth || variant.width > originalWidth {
+			continue
+		}
+		if best == nil || variant.width < best.width {
+			best = &variants[i]
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.path
+}
+
+// recordOriginalSize stores the original's dimensions on an image uploaded
+// before they were recorded, so its variants can serve as resize sources.
+// Failing to record them only costs that optimization.
+func recordOriginalSize(ctx context.Context, client *firestore.Client, imageID string, bounds image.Rectangle) {
+	_, err := client.Collection("posts").Doc(imageID).Update(ctx, []firestore.Update{
+		{Path: "Width", Value: bounds.Dx()},
+		{Path: "Height", Value: bounds.Dy()},
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "failed to record original size", "image_id", imageID, "error", err)
+	}
+}
@@ -0,0 +1,36 @@
+package functions
+
+import "testing"
+
+func TestPickVariant(t *testing.T) {
+	// The original is 1200 wide; large is stored upscaled to 1500
+	variants := []storedVariant{
+		{name: "small", path: "small.jpg", width: 300},
+		{name: "medium", path: "medium.jpg", width: 800},
+		{name: "large", path: "large.jpg", width: 1500},
+		{name: "legacy", path: "legacy.jpg"},
+		{name: "pathless", width: 900},
+	}
+	tests := []struct {
+		name          string
+		originalWidth int64
+		minWidth      float64
+		exclude       []string
+		want          string
+	}{
+		{"narrowest wide enough", 1200, 250, nil, "small.jpg"},
+		{"skips narrower", 1200, 600, nil, "medium.jpg"},
+		{"skips upscaled", 1200, 1000, nil, ""},
+		{"uses variant as wide as original", 1500, 1000, nil, "large.jpg"},
+		{"skips excluded", 1200, 250, []string{"small"}, "medium.jpg"},
+		{"skips pathless", 1200, 850, nil, ""},
+		{"none wide enough", 4000, 2000, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pickVariant(variants, tt.originalWidth, tt.minWidth, tt.exclude); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
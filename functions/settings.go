@@ -0,0 +1,85 @@
+package functions
+
+import (
+	"Project/jpegenc"
+	"Project/resample"
+	"strings"
+	"time"
+)
+
+// PipelineSettings holds the tunables of the image pipeline. main fills
+// Settings from the service configuration at startup.
+type PipelineSettings struct {
+	BucketName   string
+	SizePresets  map[string]int          // size name to target width in pixels
+	JPEG         JPEGSettings            // encoding of every output without a preset entry
+	JPEGPresets  map[string]JPEGSettings // per size preset; "original" covers uploads and watermarked_original
+	ResizeFilter resample.Filter
+	ResizeSource ResizeSourcePolicy
+	Watermark    WatermarkSettings
+	Limits       ImageLimits
+	WorkerCount  int
+	AutoVariants []string // variants generated in the background after every upload
+	// BackgroundWorkers run auto variants and renditions after their request
+	// returns; at most BackgroundQueueSize more wait, later ones are dropped
+	BackgroundWorkers   int
+	BackgroundQueueSize int
+	// RenditionFormats are the formats, in order of preference, variants are
+	// also served in to clients whose Accept header names them
+	RenditionFormats []string
+	// RenditionMaxPixels bounds the variants encoded in those formats, as
+	// their encoders are slow and memory hungry on large images; 0 for no
+	// limit
+	RenditionMaxPixels int64
+	StorageTimeout     time.Duration
+}
+
+// JPEGSettings is how one kind of output is encoded. With MaxBytes set the
+// quality is lowered, down to jpegMinQuality, until the file fits.
+type JPEGSettings struct {
+	jpegenc.Options
+	MaxBytes int
+}
+
+// jpegMinQuality is the lowest quality a byte budget may push an output to.
+const jpegMinQuality = 30
+
+type WatermarkSettings struct {
+	Path       string  // local path of the default watermark image
+	Opacity    float64 // 0 to 1
+	WidthRatio float64 // watermark width relative to the image width
+}
+
+// Settings is used by every function in this package.
+var Settings = PipelineSettings{
+	BucketName:   "halogen-device-438608-v9.appspot.com",
+	SizePresets:  map[string]int{"small": 100, "medium": 500, "large": 1500},
+	JPEG:         JPEGSettings{Options: jpegenc.Options{Quality: 90}},
+	ResizeFilter: resample.Lanczos,
+	ResizeSource: ResizeSourcePolicy{FromVariants: true, MinScale: 2},
+	Watermark: WatermarkSettings{
+		Path:       "Icares_Logo.png",
+		Opacity:    0.7,
+		WidthRatio: 0.2,
+	},
+	Limits:         DefaultLimits,
+	WorkerCount:    4,
+	StorageTimeout: 60 * time.Second,
+}
+
+// jpegSettingsFor returns the encoding of a size preset's outputs.
+func jpegSettingsFor(sizename string) JPEGSettings {
+	if settings, ok := Settings.JPEGPresets[strings.ToLower(sizename)]; ok {
+		return settings
+	}
+	return Settings.JPEG
+}
+
+// originalJPEGSettings returns the encoding of uploaded originals, which keep
+// image/jpeg's default quality unless the original preset says otherwise.
+func originalJPEGSettings() JPEGSettings {
+	if settings, ok := Settings.JPEGPresets[OriginalSize]; ok {
+		return settings
+	}
+	return JPEGSettings{Options: jpegenc.Options{Quality: jpegenc.DefaultQuality}}
+}
@@ -0,0 +1,76 @@
+package functions
+
+import (
+	"Project/resample"
+	"image"
+)
+
+// GravitySmart places a crop over the most detailed region of the image
+// instead of at a fixed anchor.
+const GravitySmart = "smart"
+
+// smartCropAnalysisSize bounds the longest side of the copy the edge energy
+// is computed on; the chosen window is scaled back to full resolution.
+const smartCropAnalysisSize = 256
+
+// smartCropRect returns the cropW x cropH window of img with the highest edge
+// energy. Energy is the gradient magnitude of the luminance, so textured,
+// high-contrast subjects win over flat sky, walls and studio backdrops.
+func smartCropRect(img image.Image, cropW, cropH int) image.Rectangle {
+	bounds := img.Bounds()
+	small := resample.Fit(img, smartCropAnalysisSize, smartCropAnalysisSize, resample.Linear)
+	sw, sh := small.Rect.Dx(), small.Rect.Dy()
+	// The shorter side is rounded, to as little as one pixel, so each axis
+	// has its own scale
+	scaleX := float64(sw) / float64(bounds.Dx())
+	scaleY := float64(sh) / float64(bounds.Dy())
+
+	luma := make([]int, sw*sh)
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			p := small.Pix[y*small.Stride+x*4:]
+			luma[y*sw+x] = (299*int(p[0]) + 587*int(p[1]) + 114*int(p[2])) / 1000
+		}
+	}
+
+	// integral[(y+1)*(sw+1)+x+1] is the energy of the rectangle (0,0)-(x,y)
+	integral := make([]int, (sw+1)*(sh+1))
+	for y := 0; y < sh; y++ {
+		row := 0
+		for x := 0; x < sw; x++ {
+			var energy int
+			if x+1 < sw {
+				energy += abs(luma[y*sw+x+1] - luma[y*sw+x])
+			}
+			if y+1 < sh {
+				energy += abs(luma[(y+1)*sw+x] - luma[y*sw+x])
+			}
+			row += energy
+			integral[(y+1)*(sw+1)+x+1] = integral[y*(sw+1)+x+1] + row
+		}
+	}
+
+	winW := min(max(int(float64(cropW)*scaleX), 1), sw)
+	winH := min(max(int(float64(cropH)*scaleY), 1), sh)
+	bestX, bestY, bestEnergy := 0, 0, -1
+	for y := 0; y+winH <= sh; y++ {
+		for x := 0; x+winW <= sw; x++ {
+			energy := integral[(y+winH)*(sw+1)+x+winW] - integral[y*(sw+1)+x+winW] -
+				integral[(y+winH)*(sw+1)+x] + integral[y*(sw+1)+x]
+			if energy > bestEnergy {
+				bestX, bestY, bestEnergy = x, y, energy
+			}
+		}
+	}
+
+	x := min(int(float64(bestX)/scaleX), bounds.Dx()-cropW)
+	y := min(int(float64(bestY)/scaleY), bounds.Dy()-cropH)
+	return image.Rect(x, y, x+cropW, y+cropH).Add(bounds.Min)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,84 @@
+package functions
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// detailedImage returns a flat gray image with black and white bands, period
+// pixels wide, filling detail.
+func detailedImage(bounds, detail image.Rectangle, period int, vertical bool) *image.RGBA {
+	img := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBA{128, 128, 128, 255}
+			if (image.Point{x, y}).In(detail) {
+				band := y / period
+				if vertical {
+					band = x / period
+				}
+				if band%2 == 0 {
+					c = color.RGBA{0, 0, 0, 255}
+				} else {
+					c = color.RGBA{255, 255, 255, 255}
+				}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestSmartCropRect(t *testing.T) {
+	tests := []struct {
+		name         string
+		img          *image.RGBA
+		detail       image.Rectangle
+		cropW, cropH int
+	}{
+		{
+			name:   "off-center square crop",
+			img:    detailedImage(image.Rect(0, 0, 400, 300), image.Rect(290, 200, 370, 260), 4, false),
+			detail: image.Rect(290, 200, 370, 260),
+			cropW:  300, cropH: 300,
+		},
+		{
+			name:   "off-center wide crop",
+			img:    detailedImage(image.Rect(0, 0, 400, 300), image.Rect(290, 200, 370, 260), 4, true),
+			detail: image.Rect(290, 200, 370, 260),
+			cropW:  400, cropH: 100,
+		},
+		{
+			name:   "not at the origin",
+			img:    detailedImage(image.Rect(50, 40, 450, 340), image.Rect(60, 60, 140, 120), 4, true),
+			detail: image.Rect(60, 60, 140, 120),
+			cropW:  200, cropH: 300,
+		},
+		{
+			// Downscaled to 1 pixel wide
+			name:   "very tall",
+			img:    detailedImage(image.Rect(0, 0, 20, 4000), image.Rect(0, 3500, 20, 3600), 40, false),
+			detail: image.Rect(0, 3500, 20, 3600),
+			cropW:  20, cropH: 200,
+		},
+		{
+			// Downscaled to 1 pixel tall
+			name:   "very wide",
+			img:    detailedImage(image.Rect(0, 0, 4000, 20), image.Rect(3300, 0, 3400, 20), 40, true),
+			detail: image.Rect(3300, 0, 3400, 20),
+			cropW:  200, cropH: 20,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := smartCropRect(tt.img, tt.cropW, tt.cropH)
+			if got.Dx() != tt.cropW || got.Dy() != tt.cropH || !got.In(tt.img.Bounds()) {
+				t.Fatalf("got %v, want a %dx%d window inside %v", got, tt.cropW, tt.cropH, tt.img.Bounds())
+			}
+			if !tt.detail.In(got) {
+				t.Errorf("got %v, which does not contain the detailed region %v", got, tt.detail)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+const watermarkedPrefix = "watermarked_"
+
+// OriginalSize stands for the full-resolution original. Only its
+// watermarked variant, "watermarked_original", exists.
+const OriginalSize = "original"
+
+// ParseVariant splits a variant name such as "small" or "watermarked_small"
+// into its size and whether it is watermarked.
+func ParseVariant(variant string) (sizename string, watermarked bool, err error) {
+	return ParseVariantIn(variant, Settings.SizePresets)
+}
+
+// ParseVariantIn is ParseVariant against the given size presets, so the
+// configuration can be checked before Settings is filled.
+func ParseVariantIn(variant string, sizePresets map[string]int) (sizename string, watermarked bool, err error) {
+	sizename, watermarked = strings.CutPrefix(variant, watermarkedPrefix)
+	if watermarked && sizename == OriginalSize {
+		return sizename, watermarked, nil
+	}
+	if _, ok := sizePresets[strings.ToLower(sizename)]; !ok {
+		return "", false, newError(ErrInvalidInput, nil, "invalid variant: %s", variant)
+	}
+	return sizename, watermarked, nil
+}
+
+func resizedObjectPath(imageID, sizename string) string {
+	return fmt.Sprintf("resized/%s_%s.jpg", sizename, imageID)
+}
+
+func watermarkedObjectPath(imageID, sizename string) string {
+	return fmt.Sprintf("watermarked/%s_watermarked_%s.jpg", sizename, imageID)
+}
+
+// VariantName is the inverse of ParseVariant.
+func VariantName(sizename string, watermarked bool) string {
+	if watermarked {
+		return watermarkedPrefix + sizename
+	}
+	return sizename
+}
+
+// GetVariantDetailsFromFirestore returns the document describing a stored variant.
+func GetVariantDetailsFromFirestore(ctx context.Context, client *firestore.Client, imageID, variant string) (map[string]interface{}, error) {
+	sizename, watermarked, err := ParseVariant(variant)
+	if err != nil {
+		return nil, err
+	}
+	if watermarked {
+		return GetWaterImageDetailFromFirestore(ctx, client, imageID, sizename)
+	}
+	return GetImageDetailsFromFireStore(ctx, client, imageID, sizename)
+}
+
+// VariantResult describes a variant that was just produced.
+type VariantResult struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// Source is the stored image the variant was made from
+	Source string `json:"source"`
+	// CreatedResize reports that a missing resize was created and stored on
+	// the way to a watermarked variant
+	CreatedResize bool `json:"createdResize"`
+}
+
+// CreateVariant resizes, or resizes and watermarks, an uploaded image.
+func CreateVariant(ctx context.Context, imageID, variant string, storageClient *storage.Client, firestoreClient *firestore.Client) (*VariantResult, error) {
+	sizename, watermarked, err := ParseVariant(variant)
+	if err != nil {
+		return nil, err
+	}
+	if watermarked {
+		return WatermarkVariant(ctx, imageID, sizename, storageClient, firestoreClient)
+	}
+	return ResizeVariant(ctx, imageID, sizename, storageClient, firestoreClient)
+}
+
+// ImageSummary is the metadata of an uploaded image and its stored variants
+// and derivatives.
+type ImageSummary struct {
+	ID          string              `json:"id"`
+	Path        string              `json:"path"`
+	Variants    []VariantSummary    `json:"variants"`
+	Derivatives []DerivativeSummary `json:"derivatives"`
+	FocalPoint  *FocalPoint         `json:"focalPoint,omitempty"`
+}
+
+type VariantSummary struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// GetImageSummary reads the image document and lists its variants.
+func GetImageSummary(ctx context.Context, client *firestore.Client, imageID string) (*ImageSummary, error) {
+	docRef := client.Collection("posts").Doc(imageID)
+	doc, err := docRef.Get(ctx)
+	if err != nil {
+		return nil, backendError(err, "failed to get image %s from Firestore", imageID)
+	}
+	path, _ := doc.Data()["Filepath"].(string)
+	summary := &ImageSummary{ID: imageID, Path: path, Variants: []VariantSummary{}, Derivatives: []DerivativeSummary{}}
+	if focal, ok := doc.Data()["FocalPoint"].(map[string]interface{}); ok {
+		x, _ := focal["X"].(float64)
+		y, _ := focal["Y"].(float64)
+		summary.FocalPoint = &FocalPoint{X: x, Y: y}
+	}
+
+	for _, collection := range []string{"resized_images", "watermarks"} {
+		iter := docRef.Collection(collection).Documents(ctx)
+		for {
+			variantDoc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return nil, backendError(err, "failed to list variants of %s", imageID)
+			}
+			variantPath, _ := variantDoc.Data()["Path"].(string)
+			summary.Variants = append(summary.Variants, VariantSummary{Name: variantDoc.Ref.ID, Path: variantPath})
+		}
+	}
+
+	iter := docRef.Collection(derivativesCollection).Documents(ctx)
+	defer iter.Stop()
+	for {
+		derivativeDoc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, backendError(err, "failed to list derivatives of %s", imageID)
+		}
+		summary.Derivatives = append(summary.Derivatives, derivativeFromDoc(derivativeDoc.Ref.ID, derivativeDoc.Data()))
+	}
+	return summary, nil
+}
+
+// NewImageID returns the ID and timestamp used for a new upload.
+func NewImageID() (imageID string, timestamp string, err error) {
+	location, err := time.LoadLocation("Asia/Kuala_Lumpur")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load timezone: %v", err)
+	}
+	timestamp = time.Now().In(location).Format("20060102_150405")
+	return fmt.Sprintf("image_%s", timestamp), timestamp, nil
+}
@@ -0,0 +1,105 @@
+package functions
+
+import (
+	"Project/resample"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+
+	"github.com/disintegration/imaging"
+)
+
+// maxScaledWatermarks bounds the scaled watermark cache. Widths come from
+// the size presets, so in practice only a handful of entries exist.
+const maxScaledWatermarks = 64
+
+var watermarkCache = struct {
+	sync.Mutex
+	path    string
+	decoded image.Image
+	scaled  map[scaledWatermarkKey]*image.RGBA
+}{scaled: map[scaledWatermarkKey]*image.RGBA{}}
+
+type scaledWatermarkKey struct {
+	source image.Image
+	width  int
+}
+
+// loadWatermark decodes the watermark at Settings.Watermark.Path once and
+// returns the same image on later calls until the path changes.
+func loadWatermark() (image.Image, error) {
+	watermarkCache.Lock()
+	defer watermarkCache.Unlock()
+	if watermarkCache.decoded != nil && watermarkCache.path == Settings.Watermark.Path {
+		return watermarkCache.decoded, nil
+	}
+	img, err := imaging.Open(Settings.Watermark.Path)
+	if err != nil {
+		return nil, err
+	}
+	watermarkCache.path = Settings.Watermark.Path
+	watermarkCache.decoded = img
+	return watermarkCache.decoded, nil
+}
+
+// scaledWatermark returns watermark resized to width, reusing earlier
+// results for the same watermark and width. Watermarks are compared by
+// identity, which is what loadWatermark provides.
+func scaledWatermark(watermark image.Image, width int) *image.RGBA {
+	key := scaledWatermarkKey{source: watermark, width: width}
+	watermarkCache.Lock()
+	scaled, ok := watermarkCache.scaled[key]
+	watermarkCache.Unlock()
+	if ok {
+		return scaled
+	}
+
+	scaled = resample.Resize(watermark, width, 0, resample.Lanczos)
+	watermarkCache.Lock()
+	if len(watermarkCache.scaled) >= maxScaledWatermarks {
+		clear(watermarkCache.scaled)
+	}
+	watermarkCache.scaled[key] = scaled
+	watermarkCache.Unlock()
+	return scaled
+}
+
+// drawWatermark composites src over dst at r with its own alpha further
+// scaled by opacity. *image.RGBA destinations are blended directly on the
+// pixel slices: both sides are premultiplied, so each channel becomes
+// src*m + dst*(1-a) with m the opacity and a the source alpha times m.
+// Other destinations go through draw.DrawMask with a uniform mask.
+func drawWatermark(dst draw.Image, r image.Rectangle, src *image.RGBA, opacity float64) {
+	mask := uint32(opacity*255 + 0.5)
+	rgba, ok := dst.(*image.RGBA)
+	if !ok {
+		draw.DrawMask(dst, r, src, src.Bounds().Min, image.NewUniform(color.Alpha{A: uint8(mask)}), image.Point{}, draw.Over)
+		return
+	}
+
+	clipped := r.Intersect(rgba.Bounds())
+	sp := src.Bounds().Min.Add(clipped.Min.Sub(r.Min))
+	r = clipped
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		d := rgba.Pix[rgba.PixOffset(r.Min.X, y):rgba.PixOffset(r.Max.X, y)]
+		s := src.Pix[src.PixOffset(sp.X, sp.Y+y-r.Min.Y):]
+		for i := 0; i < len(d); i += 4 {
+			a := div255(uint32(s[i+3]) * mask)
+			if a == 0 {
+				continue
+			}
+			inv := 255 - a
+			d[i+0] = uint8(div255(uint32(s[i+0])*mask) + div255(uint32(d[i+0])*inv))
+			d[i+1] = uint8(div255(uint32(s[i+1])*mask) + div255(uint32(d[i+1])*inv))
+			d[i+2] = uint8(div255(uint32(s[i+2])*mask) + div255(uint32(d[i+2])*inv))
+			d[i+3] = uint8(a + div255(uint32(d[i+3])*inv))
+		}
+	}
+}
+
+// div255 divides by 255 with rounding, exactly for x up to 255*255.
+func div255(x uint32) uint32 {
+	x += 128
+	return (x + x>>8) >> 8
+}
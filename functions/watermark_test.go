@@ -0,0 +1,166 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// TestUploadWatermarkName checks that named watermarks are rejected before
+// anything is decoded or stored when the name is not one path segment.
+func TestUploadWatermarkName(t *testing.T) {
+	for _, name := range []string{"", "../posts/image_1", "logo.png", "a/b", "-logo", string(make([]byte, 65))} {
+		if _, err := UploadWatermark(context.Background(), "", name, nil, nil); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("UploadWatermark(%q): got %v, want invalid input", name, err)
+		}
+	}
+}
+
+// TestDrawWatermarkBlendsByAlpha checks that partially transparent watermark
+// pixels, such as antialiased edges, cover the image in proportion to their
+// alpha times the opacity.
+func TestDrawWatermarkBlendsByAlpha(t *testing.T) {
+	alphas := []uint8{0, 1, 64, 128, 192, 254, 255}
+	src := image.NewRGBA(image.Rect(0, 0, len(alphas), 1))
+	for x, a := range alphas {
+		// Black at alpha a, premultiplied
+		src.SetRGBA(x, 0, color.RGBA{A: a})
+	}
+
+	for _, opacity := range []float64{1, 0.7, 0.25} {
+		dst := image.NewRGBA(image.Rect(0, 0, len(alphas), 1))
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		drawWatermark(dst, dst.Bounds(), src, opacity)
+
+		for x, a := range alphas {
+			coverage := float64(a) / 255 * opacity
+			want := 255 * (1 - coverage)
+			got := dst.RGBAAt(x, 0)
+			if diff := float64(got.R) - want; diff > 1 || diff < -1 {
+				t.Errorf("opacity %v, alpha %d: got %d, want %.1f", opacity, a, got.R, want)
+			}
+			if got.R != got.G || got.G != got.B || got.A != 255 {
+				t.Errorf("opacity %v, alpha %d: got %v, want opaque gray", opacity, a, got)
+			}
+		}
+	}
+}
+
+// TestDrawWatermarkMatchesDrawMask checks the pixel slice path against the
+// draw.DrawMask fallback, including a destination offset and clipping.
+func TestDrawWatermarkMatchesDrawMask(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+	// Keep the source premultiplied
+	for i := 0; i < len(src.Pix); i += 4 {
+		a := src.Pix[i+3]
+		src.Pix[i], src.Pix[i+1], src.Pix[i+2] = min(src.Pix[i], a), min(src.Pix[i+1], a), min(src.Pix[i+2], a)
+	}
+	background := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for i := range background.Pix {
+		background.Pix[i] = uint8(255 - i*3)
+	}
+	for i := 3; i < len(background.Pix); i += 4 {
+		background.Pix[i] = 255
+	}
+	r := src.Bounds().Add(image.Pt(35, 30))
+
+	// An *image.RGBA destination takes the pixel slice path, an
+	// *image.NRGBA one the draw.DrawMask fallback
+	fast := image.NewRGBA(background.Bounds())
+	draw.Draw(fast, fast.Bounds(), background, image.Point{}, draw.Src)
+	drawWatermark(fast, r, src, 0.7)
+	fallback := imaging.Clone(background)
+	drawWatermark(fallback, r, src, 0.7)
+
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			a := fast.RGBAAt(x, y)
+			b := color.RGBAModel.Convert(fallback.At(x, y)).(color.RGBA)
+			for _, d := range []int{int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B)} {
+				if d > 1 || d < -1 {
+					t.Fatalf("(%d, %d): pixel slice path %v, DrawMask path %v", x, y, a, b)
+				}
+			}
+		}
+	}
+}
+
+func benchmarkImages() (image.Image, image.Image) {
+	img := image.NewRGBA(image.Rect(0, 0, 1500, 1000))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	watermark := image.NewNRGBA(image.Rect(0, 0, 800, 400))
+	for i := range watermark.Pix {
+		watermark.Pix[i] = uint8(i * 3)
+	}
+	return img, watermark
+}
+
+// BenchmarkAddWatermark measures the current path, with the scaled
+// watermark cached after the first iteration.
+func BenchmarkAddWatermark(b *testing.B) {
+	img, watermark := benchmarkImages()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		AddWatermark(img, watermark)
+	}
+}
+
+// BenchmarkAddWatermarkPerPixel measures the implementation AddWatermark
+// replaced: resizing on every call, rebuilding the watermark's alpha pixel
+// by pixel through color.Color and compositing with draw.Draw.
+func BenchmarkAddWatermarkPerPixel(b *testing.B) {
+	img, watermark := benchmarkImages()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		width, height := img.Bounds().Dx(), img.Bounds().Dy()
+		scaled := imaging.Resize(watermark, int(float64(width)*Settings.Watermark.WidthRatio), 0, imaging.Lanczos)
+		transparent := image.NewRGBA(scaled.Bounds())
+		for y := 0; y < scaled.Bounds().Dy(); y++ {
+			for x := 0; x < scaled.Bounds().Dx(); x++ {
+				if r, g, bl, a := scaled.At(x, y).RGBA(); a > 0 {
+					transparent.Set(x, y, color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(bl >> 8), uint8(Settings.Watermark.Opacity * 255)})
+				}
+			}
+		}
+		final := image.NewRGBA(img.Bounds())
+		draw.Draw(final, final.Bounds(), img, image.Point{}, draw.Src)
+		for _, pos := range CalculateWatermarkPositions(width, height, scaled.Bounds().Dx(), scaled.Bounds().Dy(), 5) {
+			draw.Draw(final, scaled.Bounds().Add(pos), transparent, image.Point{}, draw.Over)
+		}
+	}
+}
+
+func BenchmarkDrawWatermark(b *testing.B) {
+	dst := image.NewRGBA(image.Rect(0, 0, 1500, 1000))
+	_, watermark := benchmarkImages()
+	src := scaledWatermark(watermark, 300)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		drawWatermark(dst, src.Bounds().Add(image.Pt(600, 400)), src, 0.7)
+	}
+}
+
+func BenchmarkDrawWatermarkDrawMask(b *testing.B) {
+	dst := image.NewRGBA(image.Rect(0, 0, 1500, 1000))
+	_, watermark := benchmarkImages()
+	src := scaledWatermark(watermark, 300)
+	mask := image.NewUniform(color.Alpha{A: uint8(0.7*255 + 0.5)})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		draw.DrawMask(dst, src.Bounds().Add(image.Pt(600, 400)), src, image.Point{}, mask, image.Point{}, draw.Over)
+	}
+}
@@ -0,0 +1,777 @@
+// Package jpegenc is a JPEG encoder for the options image/jpeg does not
+// offer: progressive output, 4:2:2 and 4:4:4 chroma subsampling, and
+// searching the quality that fits a byte budget. Baseline 4:2:0 and gray
+// images, which image/jpeg writes faster, are handed to image/jpeg.
+//
+// The image is converted and transformed one MCU row at a time, so no full
+// resolution copy of it is made. Baseline output is written as each row is
+// transformed. Progressive output and the quality search go over the
+// coefficients more than once and keep them, at 2 bytes per sample.
+//
+// Progressive files use spectral selection only: one interleaved DC scan
+// followed by low and high frequency AC scans per component, which every
+// progressive decoder supports and which lets viewers show a coarse image
+// after the first few percent of the file. Every scan gets Huffman tables
+// built for its own symbols and codes runs of empty bands with EOBRUN,
+// which keeps progressive files slightly smaller than baseline ones with the
+// standard tables.
+package jpegenc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"math/bits"
+	"strings"
+)
+
+// DefaultQuality matches image/jpeg.
+const DefaultQuality = 75
+
+// Subsampling is the chroma resolution relative to luma.
+type Subsampling int
+
+const (
+	Subsample420 Subsampling = iota // half width, half height
+	Subsample422                    // half width, full height
+	Subsample444                    // full resolution
+)
+
+// ParseSubsampling accepts 420, 422 and 444, with or without colons.
+func ParseSubsampling(s string) (Subsampling, error) {
+	switch strings.ReplaceAll(s, ":", "") {
+	case "420":
+		return Subsample420, nil
+	case "422":
+		return Subsample422, nil
+	case "444":
+		return Subsample444, nil
+	}
+	return 0, fmt.Errorf("unknown chroma subsampling %q, expected 420, 422 or 444", s)
+}
+
+func (s Subsampling) String() string {
+	switch s {
+	case Subsample420:
+		return "4:2:0"
+	case Subsample422:
+		return "4:2:2"
+	case Subsample444:
+		return "4:4:4"
+	}
+	return fmt.Sprintf("Subsampling(%d)", int(s))
+}
+
+// lumaSampling is the luma sampling factors; chroma is always 1x1.
+func (s Subsampling) lumaSampling() (h, v int) {
+	switch s {
+	case Subsample422:
+		return 2, 1
+	case Subsample444:
+		return 1, 1
+	}
+	return 2, 2
+}
+
+// Options configures Encode. The zero value is baseline 4:2:0 at
+// DefaultQuality.
+type Options struct {
+	Quality     int // 1 to 100
+	Progressive bool
+	Subsampling Subsampling
+}
+
+// Encode writes img as JPEG. Images whose color model is gray are written
+// with a single component and ignore Subsampling.
+func Encode(w io.Writer, img image.Image, o *Options) error {
+	if o == nil {
+		o = &Options{}
+	}
+	if usesStdlib(img, o) {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: clampQuality(o.Quality)})
+	}
+	f, err := prepare(img, o.Subsampling)
+	if err != nil {
+		return err
+	}
+	if o.Progressive {
+		f.transformAll()
+	}
+	return f.encode(w, o.Quality, o.Progressive)
+}
+
+// EncodeWithin writes img at the highest quality from minQuality up to
+// o.Quality whose output fits in maxBytes, and returns that quality. When
+// even minQuality is too large, the minQuality output is written anyway.
+// Outside image/jpeg, the color conversion and DCT are done once for the
+// whole search.
+func EncodeWithin(w io.Writer, img image.Image, o *Options, maxBytes, minQuality int) (int, error) {
+	if o == nil {
+		o = &Options{}
+	}
+	encode := func(w io.Writer, quality int) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+	if !usesStdlib(img, o) {
+		f, err := prepare(img, o.Subsampling)
+		if err != nil {
+			return 0, err
+		}
+		f.transformAll()
+		encode = func(w io.Writer, quality int) error {
+			return f.encode(w, quality, o.Progressive)
+		}
+	}
+	lo, hi := clampQuality(minQuality), clampQuality(o.Quality)
+	lo = min(lo, hi)
+	minQuality = lo
+
+	best, buf := &bytes.Buffer{}, &bytes.Buffer{}
+	bestQuality := 0
+	for lo <= hi {
+		quality := (lo + hi) / 2
+		buf.Reset()
+		if err := encode(buf, quality); err != nil {
+			return 0, err
+		}
+		if buf.Len() > maxBytes {
+			hi = quality - 1
+			continue
+		}
+		best, buf = buf, best
+		bestQuality = quality
+		lo = quality + 1
+	}
+	if bestQuality == 0 {
+		// Nothing fit; fall back to the smallest output allowed
+		if err := encode(best, minQuality); err != nil {
+			return 0, err
+		}
+		bestQuality = minQuality
+	}
+	_, err := w.Write(best.Bytes())
+	return bestQuality, err
+}
+
+// usesStdlib reports whether image/jpeg writes what o asks for: baseline
+// 4:2:0, or baseline gray, which has no chroma to subsample.
+func usesStdlib(img image.Image, o *Options) bool {
+	_, gray := img.(*image.Gray)
+	return !o.Progressive && (o.Subsampling == Subsample420 || gray)
+}
+
+func clampQuality(quality int) int {
+	if quality <= 0 {
+		return DefaultQuality
+	}
+	return min(quality, 100)
+}
+
+// coefBits is the fixed point precision of stored DCT coefficients. The
+// coefficients of 8-bit samples lie within ±1024, so 4 fractional bits
+// still fit in an int16.
+const coefBits = 4
+
+// component is one color channel and its DCT coefficients.
+type component struct {
+	id, h, v, table int
+	// blocksX x blocksY covers whole MCUs; usedX x usedY are the blocks a
+	// non-interleaved scan visits.
+	blocksX, blocksY int
+	usedX, usedY     int
+	// coefs holds 64 coefficients per block in natural order, scaled by
+	// 1<<coefBits: every block once the frame is stored, otherwise those of
+	// the MCU row being encoded.
+	coefs []int16
+}
+
+// rowLen is the number of coefficients in one MCU row of c.
+func (c *component) rowLen() int {
+	return c.v * c.blocksX * 64
+}
+
+type frame struct {
+	img           image.Image
+	width, height int
+	hmax, vmax    int
+	mcusX, mcusY  int
+	comps         []*component
+	stored        bool
+
+	// Samples of the MCU row being transformed, level shifted, at full
+	// resolution and padded to whole MCUs by repeating the edge
+	planes [][]float32
+	planeW int
+	row    *image.RGBA
+}
+
+func prepare(img image.Image, subsampling Subsampling) (*frame, error) {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 || width >= 1<<16 || height >= 1<<16 {
+		return nil, errors.New("jpegenc: image dimensions must be between 1 and 65535")
+	}
+
+	_, isGray := img.(*image.Gray)
+	hmax, vmax := 1, 1
+	if !isGray {
+		hmax, vmax = subsampling.lumaSampling()
+	}
+	f := &frame{
+		img:    img,
+		width:  width,
+		height: height,
+		hmax:   hmax,
+		vmax:   vmax,
+		mcusX:  (width + 8*hmax - 1) / (8 * hmax),
+		mcusY:  (height + 8*vmax - 1) / (8 * vmax),
+	}
+	f.planeW = f.mcusX * 8 * hmax
+	if isGray {
+		f.comps = []*component{f.newComponent(1, 1, 1, 0)}
+	} else {
+		f.comps = []*component{
+			f.newComponent(1, hmax, vmax, 0),
+			f.newComponent(2, 1, 1, 1),
+			f.newComponent(3, 1, 1, 1),
+		}
+		f.row = image.NewRGBA(image.Rect(0, 0, width, 1))
+	}
+	for range f.comps {
+		f.planes = append(f.planes, make([]float32, f.planeW*8*vmax))
+	}
+	return f, nil
+}
+
+func (f *frame) newComponent(id, h, v, table int) *component {
+	c := &component{
+		id: id, h: h, v: v, table: table,
+		blocksX: f.mcusX * h,
+		blocksY: f.mcusY * v,
+		usedX:   ((f.width*h+f.hmax-1)/f.hmax + 7) / 8,
+		usedY:   ((f.height*v+f.vmax-1)/f.vmax + 7) / 8,
+	}
+	c.coefs = make([]int16, c.rowLen())
+	return c
+}
+
+// transformAll stores the coefficients of every MCU row.
+func (f *frame) transformAll() {
+	if f.stored {
+		return
+	}
+	for _, c := range f.comps {
+		c.coefs = make([]int16, f.mcusY*c.rowLen())
+	}
+	for my := 0; my < f.mcusY; my++ {
+		f.transformRow(my)
+	}
+	f.stored = true
+}
+
+// rowCoefs returns the coefficients of MCU row my of every component,
+// transforming the row first unless the frame is stored.
+func (f *frame) rowCoefs(my int) [][]int16 {
+	rows := make([][]int16, len(f.comps))
+	if !f.stored {
+		f.transformRow(my)
+	}
+	for i, c := range f.comps {
+		if f.stored {
+			rows[i] = c.coefs[my*c.rowLen() : (my+1)*c.rowLen()]
+		} else {
+			rows[i] = c.coefs
+		}
+	}
+	return rows
+}
+
+// transformRow converts MCU row my to YCbCr, downsamples the chroma and
+// writes the DCT of each of its blocks to the components' coefficients.
+func (f *frame) transformRow(my int) {
+	b := f.img.Bounds()
+	rows := 8 * f.vmax
+	for r := 0; r < rows; r++ {
+		y := b.Min.Y + min(my*rows+r, f.height-1)
+		if gray, ok := f.img.(*image.Gray); ok {
+			pix := gray.Pix[gray.PixOffset(b.Min.X, y):]
+			plane := f.planes[0][r*f.planeW:]
+			for x := range f.planeW {
+				plane[x] = float32(pix[min(x, f.width-1)]) - 128
+			}
+			continue
+		}
+		pix := f.rgbaRow(y)
+		yp, cbp, crp := f.planes[0][r*f.planeW:], f.planes[1][r*f.planeW:], f.planes[2][r*f.planeW:]
+		for x := range f.planeW {
+			p := pix[min(x, f.width-1)*4:]
+			red, green, blue := float32(p[0]), float32(p[1]), float32(p[2])
+			yp[x] = 0.299*red + 0.587*green + 0.114*blue - 128
+			cbp[x] = -0.168736*red - 0.331264*green + 0.5*blue
+			crp[x] = 0.5*red - 0.418688*green - 0.081312*blue
+		}
+	}
+
+	var block [64]float32
+	for i, c := range f.comps {
+		plane := f.planes[i]
+		sx, sy := f.hmax/c.h, f.vmax/c.v
+		scale := 1 / float32(sx*sy)
+		// While transformAll runs, coefs already has room for every row
+		out := c.coefs
+		if len(c.coefs) > c.rowLen() {
+			out = c.coefs[my*c.rowLen():]
+		}
+		for by := 0; by < c.v; by++ {
+			for bx := 0; bx < c.blocksX; bx++ {
+				if sx == 1 && sy == 1 {
+					for y := 0; y < 8; y++ {
+						copy(block[y*8:y*8+8], plane[(by*8+y)*f.planeW+bx*8:])
+					}
+					fdct(&block, out[(by*c.blocksX+bx)*64:])
+					continue
+				}
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						var sum float32
+						for dy := 0; dy < sy; dy++ {
+							row := ((by*8+y)*sy + dy) * f.planeW
+							for dx := 0; dx < sx; dx++ {
+								sum += plane[row+(bx*8+x)*sx+dx]
+							}
+						}
+						block[y*8+x] = sum * scale
+					}
+				}
+				fdct(&block, out[(by*c.blocksX+bx)*64:])
+			}
+		}
+	}
+}
+
+// rgbaRow returns source row y as RGBA, in place when the image is RGBA.
+func (f *frame) rgbaRow(y int) []uint8 {
+	b := f.img.Bounds()
+	if rgba, ok := f.img.(*image.RGBA); ok {
+		return rgba.Pix[rgba.PixOffset(b.Min.X, y):]
+	}
+	draw.Draw(f.row, f.row.Rect, f.img, image.Pt(b.Min.X, y), draw.Src)
+	return f.row.Pix
+}
+
+// fdctScale undoes the scaling the AAN transform leaves in each output and
+// applies coefBits.
+var fdctScale = func() (s [64]float32) {
+	aan := [8]float64{1, 1.387039845, 1.306562965, 1.175875602, 1, 0.785694958, 0.541196100, 0.275899379}
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			s[v*8+u] = float32(float64(int(1)<<coefBits) / (aan[u] * aan[v] * 8))
+		}
+	}
+	return s
+}()
+
+// fdct writes the 2-D DCT of block to out using the Arai, Agui and Nakajima
+// factorization, as libjpeg's floating point DCT does. block is overwritten.
+func fdct(block *[64]float32, out []int16) {
+	for i := 0; i < 64; i += 8 {
+		fdct1D(block[i:i+8:i+8], 1)
+	}
+	for i := 0; i < 8; i++ {
+		fdct1D(block[i:], 8)
+	}
+	out = out[:64]
+	for i, v := range block {
+		// Offsetting to positive values makes truncation round, without a
+		// branch on the sign
+		out[i] = int16(int32(v*fdctScale[i]+32768.5) - 32768)
+	}
+}
+
+// fdct1D transforms the 8 values of d that are stride apart.
+func fdct1D(d []float32, stride int) {
+	d0, d1, d2, d3 := d[0], d[stride], d[2*stride], d[3*stride]
+	d4, d5, d6, d7 := d[4*stride], d[5*stride], d[6*stride], d[7*stride]
+	tmp0, tmp7 := d0+d7, d0-d7
+	tmp1, tmp6 := d1+d6, d1-d6
+	tmp2, tmp5 := d2+d5, d2-d5
+	tmp3, tmp4 := d3+d4, d3-d4
+
+	// Even part
+	tmp10, tmp13 := tmp0+tmp3, tmp0-tmp3
+	tmp11, tmp12 := tmp1+tmp2, tmp1-tmp2
+	d[0] = tmp10 + tmp11
+	d[4*stride] = tmp10 - tmp11
+	z1 := (tmp12 + tmp13) * 0.707106781
+	d[2*stride] = tmp13 + z1
+	d[6*stride] = tmp13 - z1
+
+	// Odd part
+	tmp10, tmp11, tmp12 = tmp4+tmp5, tmp5+tmp6, tmp6+tmp7
+	z5 := (tmp10 - tmp12) * 0.382683433
+	z2 := 0.541196100*tmp10 + z5
+	z4 := 1.306562965*tmp12 + z5
+	z3 := tmp11 * 0.707106781
+	z11, z13 := tmp7+z3, tmp7-z3
+	d[5*stride] = z13 + z2
+	d[3*stride] = z13 - z2
+	d[stride] = z11 + z4
+	d[7*stride] = z11 - z4
+}
+
+// zigzag maps the position in the zig-zag scan to the natural index.
+var zigzag = func() (z [64]int) {
+	i := 0
+	for d := 0; d < 15; d++ {
+		for j := 0; j <= d; j++ {
+			y := j
+			if d%2 == 0 {
+				y = d - j
+			}
+			if x := d - y; x < 8 && y < 8 {
+				z[i] = y*8 + x
+				i++
+			}
+		}
+	}
+	return z
+}()
+
+// quantTables scales the base tables the way libjpeg does.
+func quantTables(quality int) (q [2][64]int) {
+	quality = clampQuality(quality)
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	for t := range q {
+		for i, base := range baseQuant[t] {
+			q[t][i] = min(max((base*scale+50)/100, 1), 255)
+		}
+	}
+	return q
+}
+
+// quantize divides a stored coefficient by a quantization step, given as
+// its reciprocal, rounding to nearest. Baseline Huffman tables code
+// magnitudes up to 1023.
+func quantize(coef int16, reciprocal float32) int32 {
+	v := float32(coef) * reciprocal
+	if v < 0 {
+		v -= 0.5
+	} else {
+		v += 0.5
+	}
+	return min(max(int32(v), -1023), 1023)
+}
+
+type bitWriter struct {
+	w     *bufio.Writer
+	bits  uint32
+	nBits uint
+}
+
+func (b *bitWriter) write(bits uint32, n uint) {
+	b.bits = b.bits<<n | bits&(1<<n-1)
+	b.nBits += n
+	for b.nBits >= 8 {
+		c := byte(b.bits >> (b.nBits - 8))
+		b.w.WriteByte(c)
+		if c == 0xff {
+			b.w.WriteByte(0)
+		}
+		b.nBits -= 8
+	}
+}
+
+// flush pads the last byte with ones, as T.81 requires.
+func (b *bitWriter) flush() {
+	if b.nBits > 0 {
+		b.write(0x7f, 8-b.nBits)
+	}
+	b.bits = 0
+}
+
+// scan is a set of components and the range of zig-zag coefficients coded
+// together.
+type scan struct {
+	comps  []int
+	ss, se int
+}
+
+// encoder holds the state of one encoding pass at a given quality.
+type encoder struct {
+	*frame
+	bw bitWriter
+	// reciprocals of the quantization steps, scaled like the coefficients,
+	// and half those steps, below which coefficients quantize to zero
+	reciprocals [2][64]float32
+	halfSteps   [2][64]int32
+	quant       [2][64]int
+	codes       [4][256]huffmanCode
+	// counts gathers symbol frequencies instead of writing when non-nil
+	counts      *[4][256]int
+	progressive bool
+	pred        []int32
+	eobrun      int
+}
+
+func (f *frame) encode(w io.Writer, quality int, progressive bool) error {
+	e := &encoder{frame: f, bw: bitWriter{w: bufio.NewWriter(w)}, quant: quantTables(quality), progressive: progressive}
+	for t := range e.quant {
+		for i, q := range e.quant[t] {
+			e.reciprocals[t][i] = 1 / float32(q<<coefBits)
+			e.halfSteps[t][i] = int32(q) << (coefBits - 1)
+		}
+	}
+
+	e.writeHeaders(progressive)
+	if progressive {
+		scans := []scan{{comps: e.allComponents(), ss: 0, se: 0}}
+		for i := range f.comps {
+			scans = append(scans, scan{comps: []int{i}, ss: 1, se: 5}, scan{comps: []int{i}, ss: 6, se: 63})
+		}
+		for _, s := range scans {
+			e.writeOptimizedTables(s)
+			e.writeScan(s)
+		}
+	} else {
+		for t, spec := range huffmanSpecs {
+			e.codes[t] = spec.codes()
+		}
+		e.writeScan(scan{comps: e.allComponents(), ss: 0, se: 63})
+	}
+	e.marker(0xd9, nil)
+	return e.bw.w.Flush()
+}
+
+func (e *encoder) allComponents() []int {
+	all := make([]int, len(e.comps))
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
+
+func (e *encoder) marker(code byte, payload []byte) {
+	e.bw.w.Write([]byte{0xff, code})
+	if payload != nil {
+		n := len(payload) + 2
+		e.bw.w.Write([]byte{byte(n >> 8), byte(n)})
+		e.bw.w.Write(payload)
+	}
+}
+
+func (e *encoder) writeHeaders(progressive bool) {
+	e.marker(0xd8, nil)
+	e.marker(0xe0, []byte{'J', 'F', 'I', 'F', 0, 1, 1, 0, 0, 1, 0, 1, 0, 0})
+
+	tables := 1
+	if len(e.comps) > 1 {
+		tables = 2
+	}
+	var dqt []byte
+	for t := 0; t < tables; t++ {
+		dqt = append(dqt, byte(t))
+		for _, i := range zigzag {
+			dqt = append(dqt, byte(e.quant[t][i]))
+		}
+	}
+	e.marker(0xdb, dqt)
+
+	sof := []byte{8, byte(e.height >> 8), byte(e.height), byte(e.width >> 8), byte(e.width), byte(len(e.comps))}
+	for _, c := range e.comps {
+		sof = append(sof, byte(c.id), byte(c.h<<4|c.v), byte(c.table))
+	}
+	if progressive {
+		// Each scan defines its own Huffman tables
+		e.marker(0xc2, sof)
+		return
+	}
+	e.marker(0xc0, sof)
+
+	var slots []int
+	for t := 0; t < 2*tables; t++ {
+		slots = append(slots, t)
+	}
+	e.writeDHT(slots, huffmanSpecs[:])
+}
+
+// writeDHT defines the Huffman tables listed in slots, which index both
+// specs and huffmanSpecs' layout of DC and AC tables per table number.
+func (e *encoder) writeDHT(slots []int, specs []huffmanSpec) {
+	var dht []byte
+	for _, t := range slots {
+		class, id := t%2, t/2
+		dht = append(dht, byte(class<<4|id))
+		dht = append(dht, specs[t].counts[:]...)
+		dht = append(dht, specs[t].values...)
+	}
+	e.marker(0xc4, dht)
+}
+
+// writeOptimizedTables runs s without output to count its symbols, then
+// defines and selects Huffman tables built for those counts.
+func (e *encoder) writeOptimizedTables(s scan) {
+	e.counts = &[4][256]int{}
+	e.runScan(s)
+	counts := e.counts
+	e.counts = nil
+
+	var specs [4]huffmanSpec
+	var slots []int
+	for t := range counts {
+		used := false
+		for _, n := range counts[t] {
+			used = used || n > 0
+		}
+		if !used {
+			continue
+		}
+		specs[t] = optimalSpec(&counts[t])
+		e.codes[t] = specs[t].codes()
+		slots = append(slots, t)
+	}
+	e.writeDHT(slots, specs[:])
+}
+
+func (e *encoder) writeScan(s scan) {
+	sos := []byte{byte(len(s.comps))}
+	for _, i := range s.comps {
+		t := e.comps[i].table
+		sos = append(sos, byte(e.comps[i].id), byte(t<<4|t))
+	}
+	sos = append(sos, byte(s.ss), byte(s.se), 0)
+	e.marker(0xda, sos)
+	e.runScan(s)
+	e.bw.flush()
+}
+
+// runScan codes coefficients ss to se of the listed components. A scan of
+// several components is interleaved MCU by MCU; a single component scan
+// visits its blocks in raster order.
+func (e *encoder) runScan(s scan) {
+	e.pred = make([]int32, len(e.comps))
+	e.eobrun = 0
+	// A single component frame is coded in raster order either way
+	if len(s.comps) == 1 && len(e.comps) > 1 {
+		i := s.comps[0]
+		c := e.comps[i]
+		for by := 0; by < c.usedY; by++ {
+			for bx := 0; bx < c.usedX; bx++ {
+				n := (by*c.blocksX + bx) * 64
+				e.writeBlock(i, c.coefs[n:n+64], s.ss, s.se)
+			}
+		}
+	} else {
+		for my := 0; my < e.mcusY; my++ {
+			rows := e.rowCoefs(my)
+			for mx := 0; mx < e.mcusX; mx++ {
+				for _, i := range s.comps {
+					c := e.comps[i]
+					for y := 0; y < c.v; y++ {
+						for x := 0; x < c.h; x++ {
+							n := (y*c.blocksX + mx*c.h + x) * 64
+							e.writeBlock(i, rows[i][n:n+64], s.ss, s.se)
+						}
+					}
+				}
+			}
+		}
+	}
+	if e.eobrun > 0 {
+		e.writeEOBRun(2*e.comps[s.comps[0]].table + 1)
+	}
+}
+
+// writeBlock codes coefficients ss to se of one block. Progressive AC scans
+// count blocks ending in zeros into an end-of-band run instead of coding an
+// EOB for each.
+func (e *encoder) writeBlock(comp int, coefs []int16, ss, se int) {
+	table := e.comps[comp].table
+	reciprocals, halfSteps := &e.reciprocals[table], &e.halfSteps[table]
+	dcTable, acTable := 2*table, 2*table+1
+	if ss == 0 {
+		dc := quantize(coefs[0], reciprocals[0])
+		e.value(dcTable, 0, dc-e.pred[comp])
+		e.pred[comp] = dc
+		if se == 0 {
+			return
+		}
+		ss = 1
+	}
+	run := 0
+	for k := ss; k <= se; k++ {
+		i := zigzag[k]
+		// Most coefficients are zero; tell them apart without rounding
+		if c, half := int32(coefs[i]), halfSteps[i]; c > -half && c < half {
+			run++
+			continue
+		}
+		v := quantize(coefs[i], reciprocals[i])
+		if e.eobrun > 0 {
+			e.writeEOBRun(acTable)
+		}
+		for run > 15 {
+			e.symbol(acTable, 0xf0)
+			run -= 16
+		}
+		e.value(acTable, run, v)
+		run = 0
+	}
+	if run == 0 {
+		return
+	}
+	if !e.progressive {
+		e.symbol(acTable, 0x00)
+		return
+	}
+	e.eobrun++
+	if e.eobrun == 0x7fff {
+		e.writeEOBRun(acTable)
+	}
+}
+
+// writeEOBRun codes the pending end-of-band run: its bit length as the
+// EOBn symbol, then the bits below the leading one.
+func (e *encoder) writeEOBRun(table int) {
+	n := uint(bits.Len(uint(e.eobrun)) - 1)
+	e.symbol(table, byte(n<<4))
+	if n > 0 {
+		e.bits(uint32(e.eobrun), n)
+	}
+	e.eobrun = 0
+}
+
+func (e *encoder) symbol(table int, symbol byte) {
+	if e.counts != nil {
+		e.counts[table][symbol]++
+		return
+	}
+	c := e.codes[table][symbol]
+	e.bw.write(c.code, c.size)
+}
+
+func (e *encoder) bits(bits uint32, n uint) {
+	if e.counts == nil {
+		e.bw.write(bits, n)
+	}
+}
+
+// value writes the category symbol of v followed by its magnitude bits.
+func (e *encoder) value(table int, run int, v int32) {
+	a, magnitude := v, v
+	if v < 0 {
+		a, magnitude = -v, v-1
+	}
+	size := uint(bits.Len32(uint32(a)))
+	e.symbol(table, byte(run<<4)|byte(size))
+	if size > 0 {
+		e.bits(uint32(magnitude), size)
+	}
+}
@@ -0,0 +1,356 @@
+package jpegenc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// testImage returns a photo-like image: smooth gradients, some texture and
+// a few hard edges, with dimensions that are not multiples of an MCU.
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	rng := rand.New(rand.NewSource(1))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+			r := 128 + 100*math.Sin(6*fx+2*fy)
+			g := 128 + 90*math.Cos(5*fy-3*fx)
+			b := 128 + 80*math.Sin(9*fx*fy)
+			if (x/37+y/23)%5 == 0 {
+				r, g, b = r/3, g/3, 255-b/4
+			}
+			noise := rng.Float64()*12 - 6
+			img.SetRGBA(x, y, color.RGBA{clamp8(r + noise), clamp8(g + noise), clamp8(b + noise), 255})
+		}
+	}
+	return img
+}
+
+func clamp8(v float64) uint8 {
+	return uint8(min(max(math.Round(v), 0), 255))
+}
+
+// meanError returns the mean absolute difference per channel between want
+// and got, compared from their respective origins.
+func meanError(t *testing.T, want, got image.Image) float64 {
+	t.Helper()
+	wb, gb := want.Bounds(), got.Bounds()
+	if wb.Dx() != gb.Dx() || wb.Dy() != gb.Dy() {
+		t.Fatalf("decoded %v, want the size of %v", gb, wb)
+	}
+	var sum float64
+	for y := 0; y < wb.Dy(); y++ {
+		for x := 0; x < wb.Dx(); x++ {
+			r1, g1, b1, _ := want.At(wb.Min.X+x, wb.Min.Y+y).RGBA()
+			r2, g2, b2, _ := got.At(gb.Min.X+x, gb.Min.Y+y).RGBA()
+			sum += math.Abs(float64(r1>>8)-float64(r2>>8)) + math.Abs(float64(g1>>8)-float64(g2>>8)) + math.Abs(float64(b1>>8)-float64(b2>>8))
+		}
+	}
+	return sum / float64(3*wb.Dx()*wb.Dy())
+}
+
+func encode(t *testing.T, img image.Image, o *Options) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, o); err != nil {
+		t.Fatalf("Encode(%+v): %v", o, err)
+	}
+	return buf.Bytes()
+}
+
+func decode(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	return img
+}
+
+// sofMarker returns the second byte of the frame header marker: 0xc0 for
+// baseline, 0xc2 for progressive.
+func sofMarker(t *testing.T, data []byte) byte {
+	t.Helper()
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			t.Fatalf("no marker at offset %d", i)
+		}
+		if marker := data[i+1]; marker >= 0xc0 && marker <= 0xc2 {
+			return marker
+		}
+		i += 2 + int(data[i+2])<<8 + int(data[i+3])
+	}
+	t.Fatal("no frame header")
+	return 0
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	src := testImage(203, 117)
+	tests := []struct {
+		name string
+		o    Options
+		sof  byte
+		want image.YCbCrSubsampleRatio
+	}{
+		{"baseline 4:2:0", Options{Quality: 90}, 0xc0, image.YCbCrSubsampleRatio420},
+		{"baseline 4:2:2", Options{Quality: 90, Subsampling: Subsample422}, 0xc0, image.YCbCrSubsampleRatio422},
+		{"baseline 4:4:4", Options{Quality: 90, Subsampling: Subsample444}, 0xc0, image.YCbCrSubsampleRatio444},
+		{"progressive 4:2:0", Options{Quality: 90, Progressive: true}, 0xc2, image.YCbCrSubsampleRatio420},
+		{"progressive 4:2:2", Options{Quality: 90, Progressive: true, Subsampling: Subsample422}, 0xc2, image.YCbCrSubsampleRatio422},
+		{"progressive 4:4:4", Options{Quality: 90, Progressive: true, Subsampling: Subsample444}, 0xc2, image.YCbCrSubsampleRatio444},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encode(t, src, &tt.o)
+			if got := sofMarker(t, data); got != tt.sof {
+				t.Errorf("frame marker %#x, want %#x", got, tt.sof)
+			}
+			got := decode(t, data)
+			ycbcr, ok := got.(*image.YCbCr)
+			if !ok {
+				t.Fatalf("decoded %T, want *image.YCbCr", got)
+			}
+			if ycbcr.SubsampleRatio != tt.want {
+				t.Errorf("subsampling %v, want %v", ycbcr.SubsampleRatio, tt.want)
+			}
+			if e := meanError(t, src, got); e > 4 {
+				t.Errorf("mean error %.2f, want at most 4", e)
+			}
+		})
+	}
+}
+
+// TestProgressiveMatchesBaseline checks that progressive output decodes to
+// the same pixels as baseline output, since both code the same quantized
+// coefficients, and that it is not larger.
+func TestProgressiveMatchesBaseline(t *testing.T) {
+	src := testImage(640, 480)
+	for _, subsampling := range []Subsampling{Subsample422, Subsample444} {
+		for _, quality := range []int{30, 75, 95} {
+			baseline := encode(t, src, &Options{Quality: quality, Subsampling: subsampling})
+			progressive := encode(t, src, &Options{Quality: quality, Subsampling: subsampling, Progressive: true})
+			b, p := decode(t, baseline).(*image.YCbCr), decode(t, progressive).(*image.YCbCr)
+			if !bytes.Equal(b.Y, p.Y) || !bytes.Equal(b.Cb, p.Cb) || !bytes.Equal(b.Cr, p.Cr) {
+				t.Errorf("%v quality %d: progressive decodes differently from baseline", subsampling, quality)
+			}
+			if len(progressive) > len(baseline) {
+				t.Errorf("%v quality %d: progressive is %d bytes, baseline %d", subsampling, quality, len(progressive), len(baseline))
+			}
+		}
+	}
+}
+
+// TestStdlibBaseline checks that baseline 4:2:0 output is image/jpeg's.
+func TestStdlibBaseline(t *testing.T) {
+	src := testImage(120, 90)
+	var want bytes.Buffer
+	if err := jpeg.Encode(&want, src, &jpeg.Options{Quality: 80}); err != nil {
+		t.Fatal(err)
+	}
+	if got := encode(t, src, &Options{Quality: 80}); !bytes.Equal(got, want.Bytes()) {
+		t.Error("baseline 4:2:0 output differs from image/jpeg")
+	}
+}
+
+func TestEncodeGray(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 77, 45))
+	draw.Draw(src, src.Rect, testImage(77, 45), image.Point{}, draw.Src)
+	for _, o := range []Options{
+		{Quality: 90, Subsampling: Subsample444},
+		{Quality: 90, Progressive: true},
+		{Quality: 90, Progressive: true, Subsampling: Subsample422},
+	} {
+		data := encode(t, src, &o)
+		got := decode(t, data)
+		if _, ok := got.(*image.Gray); !ok {
+			t.Fatalf("%+v: decoded %T, want *image.Gray", o, got)
+		}
+		if e := meanError(t, src, got); e > 3 {
+			t.Errorf("%+v: mean error %.2f, want at most 3", o, e)
+		}
+	}
+}
+
+// TestEncodeSubImage encodes sub-images whose bounds do not start at 0,0,
+// through both the RGBA fast path and the conversion of other types.
+func TestEncodeSubImage(t *testing.T) {
+	full := testImage(300, 200)
+	r := image.Rect(57, 33, 250, 170)
+	nrgba := image.NewNRGBA(full.Rect)
+	draw.Draw(nrgba, nrgba.Rect, full, image.Point{}, draw.Src)
+	gray := image.NewGray(full.Rect)
+	draw.Draw(gray, gray.Rect, full, image.Point{}, draw.Src)
+
+	for name, sub := range map[string]image.Image{
+		"RGBA":  full.SubImage(r),
+		"NRGBA": nrgba.SubImage(r),
+		"Gray":  gray.SubImage(r),
+	} {
+		for _, o := range []Options{
+			{Quality: 90},
+			{Quality: 90, Subsampling: Subsample444},
+			{Quality: 90, Progressive: true, Subsampling: Subsample422},
+		} {
+			got := decode(t, encode(t, sub, &o))
+			if got.Bounds() != image.Rect(0, 0, r.Dx(), r.Dy()) {
+				t.Fatalf("%s %+v: decoded bounds %v, want %dx%d", name, o, got.Bounds(), r.Dx(), r.Dy())
+			}
+			if e := meanError(t, sub, got); e > 4 {
+				t.Errorf("%s %+v: mean error %.2f, want at most 4", name, o, e)
+			}
+		}
+	}
+}
+
+func TestEncodeWithin(t *testing.T) {
+	src := testImage(320, 240)
+	for _, o := range []Options{
+		{Quality: 95},
+		{Quality: 95, Subsampling: Subsample444},
+		{Quality: 95, Progressive: true},
+	} {
+		budget := len(encode(t, src, &Options{Quality: 60, Progressive: o.Progressive, Subsampling: o.Subsampling})) + 100
+
+		var buf bytes.Buffer
+		quality, err := EncodeWithin(&buf, src, &o, budget, 30)
+		if err != nil {
+			t.Fatalf("%+v: %v", o, err)
+		}
+		if buf.Len() > budget {
+			t.Errorf("%+v: wrote %d bytes, budget %d", o, buf.Len(), budget)
+		}
+		if quality < 60 || quality >= o.Quality {
+			t.Errorf("%+v: quality %d, want from 60 up to %d", o, quality, o.Quality)
+		}
+		// The next quality up must not have fit
+		next := o
+		next.Quality = quality + 1
+		if n := len(encode(t, src, &next)); n <= budget {
+			t.Errorf("%+v: quality %d fits in %d bytes too", o, quality+1, n)
+		}
+		if !bytes.Equal(buf.Bytes(), encode(t, src, &Options{Quality: quality, Progressive: o.Progressive, Subsampling: o.Subsampling})) {
+			t.Errorf("%+v: output differs from Encode at quality %d", o, quality)
+		}
+		decode(t, buf.Bytes())
+
+		// An impossible budget falls back to the minimum quality
+		buf.Reset()
+		quality, err = EncodeWithin(&buf, src, &o, 100, 30)
+		if err != nil || quality != 30 {
+			t.Errorf("%+v: impossible budget gave quality %d, %v; want 30", o, quality, err)
+		}
+	}
+}
+
+// TestFDCT compares the AAN transform with the DCT definition.
+func TestFDCT(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for n := 0; n < 20; n++ {
+		var block, input [64]float32
+		for i := range block {
+			block[i] = float32(rng.Intn(256) - 128)
+		}
+		input = block
+		var got [64]int16
+		fdct(&block, got[:])
+		for v := 0; v < 8; v++ {
+			for u := 0; u < 8; u++ {
+				var sum float64
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						sum += float64(input[y*8+x]) * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16) * math.Cos(float64(2*y+1)*float64(v)*math.Pi/16)
+					}
+				}
+				cu, cv := 1.0, 1.0
+				if u == 0 {
+					cu = 1 / math.Sqrt2
+				}
+				if v == 0 {
+					cv = 1 / math.Sqrt2
+				}
+				want := cu * cv / 4 * sum * (1 << coefBits)
+				if diff := math.Abs(float64(got[v*8+u]) - want); diff > 1 {
+					t.Fatalf("coefficient (%d, %d): got %d, want %.1f", u, v, got[v*8+u], want)
+				}
+			}
+		}
+	}
+}
+
+// TestOptimalSpec checks that built tables give every used symbol a code of
+// at most 16 bits, leave the all ones code free and satisfy the Kraft
+// inequality, including for frequencies that would need longer codes.
+func TestOptimalSpec(t *testing.T) {
+	var skewed [256]int
+	a, b := 1, 1
+	for i := 0; i < 40; i++ {
+		skewed[i] = a
+		a, b = b, a+b
+	}
+	var single [256]int
+	single[0x00] = 5
+	var uniform [256]int
+	for i := range uniform {
+		uniform[i] = 1
+	}
+
+	for name, freq := range map[string]*[256]int{"fibonacci": &skewed, "single": &single, "uniform": &uniform} {
+		spec := optimalSpec(freq)
+		codes := spec.codes()
+		var kraft float64
+		total := 0
+		for length, count := range spec.counts {
+			kraft += float64(count) / float64(uint(1)<<(length+1))
+			total += int(count)
+		}
+		if total != len(spec.values) {
+			t.Fatalf("%s: %d codes for %d values", name, total, len(spec.values))
+		}
+		if kraft >= 1 {
+			t.Errorf("%s: Kraft sum %v leaves no all ones code free", name, kraft)
+		}
+		for symbol, n := range freq {
+			if n == 0 {
+				continue
+			}
+			if c := codes[symbol]; c.size == 0 || c.size > 16 {
+				t.Errorf("%s: symbol %#x has a %d bit code", name, symbol, c.size)
+			}
+		}
+	}
+}
+
+func benchmarkEncode(b *testing.B, o *Options) {
+	src := testImage(4000, 3000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		if err := Encode(&buf, src, o); err != nil {
+			b.Fatal(err)
+		}
+		b.ReportMetric(float64(buf.Len()), "bytes")
+	}
+}
+
+func BenchmarkEncodeBaseline420(b *testing.B) {
+	benchmarkEncode(b, &Options{Quality: 85})
+}
+
+func BenchmarkEncodeBaseline444(b *testing.B) {
+	benchmarkEncode(b, &Options{Quality: 85, Subsampling: Subsample444})
+}
+
+func BenchmarkEncodeProgressive420(b *testing.B) {
+	benchmarkEncode(b, &Options{Quality: 85, Progressive: true})
+}
+
+func BenchmarkEncodeProgressive444(b *testing.B) {
+	benchmarkEncode(b, &Options{Quality: 85, Progressive: true, Subsampling: Subsample444})
+}
@@ -0,0 +1,106 @@
+package jpegenc
+
+type huffmanCode struct {
+	code uint32
+	size uint
+}
+
+// codes assigns the canonical codes of s to its symbols.
+func (s huffmanSpec) codes() (codes [256]huffmanCode) {
+	code, k := uint32(0), 0
+	for length, count := range s.counts {
+		for i := 0; i < int(count); i++ {
+			codes[s.values[k]] = huffmanCode{code: code, size: uint(length + 1)}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// optimalSpec builds a Huffman table for the symbol frequencies in freq with
+// codes of at most 16 bits, following T.81 Annex K.2 as libjpeg does.
+func optimalSpec(freq *[256]int) huffmanSpec {
+	var f [257]int
+	copy(f[:], freq[:])
+	// A reserved symbol takes the all ones code, which no real code may use
+	f[256] = 1
+
+	var codesize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+	for {
+		// Merge the two least frequent trees, preferring the larger symbol
+		// on ties so the reserved symbol ends up with the longest code
+		c1, c2 := -1, -1
+		for i := range f {
+			if f[i] > 0 && (c1 < 0 || f[i] <= f[c1]) {
+				c1 = i
+			}
+		}
+		for i := range f {
+			if f[i] > 0 && i != c1 && (c2 < 0 || f[i] <= f[c2]) {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		f[c1] += f[c2]
+		f[c2] = 0
+		codesize[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			codesize[c1]++
+		}
+		others[c1] = c2
+		codesize[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			codesize[c2]++
+		}
+	}
+
+	var lengths [258]int
+	for _, size := range codesize {
+		if size > 0 {
+			lengths[size]++
+		}
+	}
+	// Shorten codes longer than 16 bits: move two of them up to a shorter
+	// length whose code is split in two
+	for i := len(lengths) - 1; i > 16; i-- {
+		for lengths[i] > 0 {
+			j := i - 2
+			for lengths[j] == 0 {
+				j--
+			}
+			lengths[i] -= 2
+			lengths[i-1]++
+			lengths[j+1] += 2
+			lengths[j]--
+		}
+	}
+	// Drop the reserved code, the longest one
+	i := 16
+	for lengths[i] == 0 {
+		i--
+	}
+	lengths[i]--
+
+	var spec huffmanSpec
+	for length := 1; length <= 16; length++ {
+		spec.counts[length-1] = byte(lengths[length])
+	}
+	for length := 1; length < len(lengths); length++ {
+		for symbol := 0; symbol < 256; symbol++ {
+			if codesize[symbol] == length {
+				spec.values = append(spec.values, byte(symbol))
+			}
+		}
+	}
+	return spec
+}
@@ -2,9 +2,20 @@ package main
 
 import (
 	"Project/configs"
+	"Project/functions"
+	"Project/logging"
+	"Project/resample"
 	"Project/routes"
-	_ "image/png" // Import image packages to support PNG, JPEG, etc.
+	"Project/tracing"
+	"context"
+	"errors"
 	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,15 +23,129 @@ import (
 func main() {
 
 	configs.InitiEnvConfigs()
-	err := routes.InitializeClients()
+	if err := logging.Init(configs.EnvConfigs.LogLevel, configs.EnvConfigs.LogFormat); err != nil {
+		log.Fatalf("Failed to initialize logging: %v", err)
+	}
+	if err := run(); err != nil {
+		slog.Error("server stopped with error", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	sizePresets, err := configs.EnvConfigs.SizePresetWidths()
+	if err != nil {
+		return err
+	}
+	resizeFilter, err := resample.ParseFilter(configs.EnvConfigs.ResizeFilter)
+	if err != nil {
+		return err
+	}
+	jpegDefaults, jpegOutputs, err := configs.EnvConfigs.JPEGOutputs()
+	if err != nil {
+		return err
+	}
+	jpegPresets := make(map[string]functions.JPEGSettings, len(jpegOutputs))
+	for name, output := range jpegOutputs {
+		jpegPresets[name] = functions.JPEGSettings(output)
+	}
+	functions.Settings = functions.PipelineSettings{
+		BucketName:   configs.EnvConfigs.BucketName,
+		SizePresets:  sizePresets,
+		JPEG:         functions.JPEGSettings(jpegDefaults),
+		JPEGPresets:  jpegPresets,
+		ResizeFilter: resizeFilter,
+		ResizeSource: functions.ResizeSourcePolicy{
+			FromVariants: configs.EnvConfigs.ResizeFromVariants,
+			MinScale:     configs.EnvConfigs.ResizeSourceMinScale,
+		},
+		Watermark: functions.WatermarkSettings{
+			Path:       configs.EnvConfigs.WatermarkPath,
+			Opacity:    configs.EnvConfigs.WatermarkOpacity,
+			WidthRatio: configs.EnvConfigs.WatermarkWidthRatio,
+		},
+		Limits: functions.ImageLimits{
+			MaxBytes:       configs.EnvConfigs.MaxImageBytes,
+			MaxWidth:       configs.EnvConfigs.MaxImageWidth,
+			MaxHeight:      configs.EnvConfigs.MaxImageHeight,
+			MaxPixels:      configs.EnvConfigs.MaxImagePixels,
+			AllowedFormats: configs.EnvConfigs.AllowedFormats(),
+		},
+		WorkerCount:         configs.EnvConfigs.WorkerCount,
+		AutoVariants:        configs.EnvConfigs.AutoVariantList(),
+		BackgroundWorkers:   configs.EnvConfigs.BackgroundWorkers,
+		BackgroundQueueSize: configs.EnvConfigs.BackgroundQueueSize,
+		RenditionFormats:    configs.EnvConfigs.RenditionFormatList(),
+		RenditionMaxPixels:  configs.EnvConfigs.RenditionMaxPixels,
+		StorageTimeout:      configs.EnvConfigs.StorageTimeout,
+	}
+	shutdownTracing, err := tracing.Init(context.Background(), configs.EnvConfigs.TracesExporter)
+	if err != nil {
+		return err
+	}
+
+	err = routes.InitializeClients()
 	if err != nil {
-		log.Fatalf("Failed to initialize clients: %v", err)
+		return err
 	}
+	functions.CheckRenditionFormats()
+	functions.StartBackgroundWorkers()
 	routes.InitializeRoutes()
 	routes.Router.Static("/static", "./static")
 	routes.Router.GET("/", func(c *gin.Context) {
 		c.File("./static/index.html") // Serve index.html directly
 	})
-	routes.Router.Run(":" + configs.EnvConfigs.LocalServerPort)
 
+	server := &http.Server{
+		Addr:    ":" + configs.EnvConfigs.LocalServerPort,
+		Handler: routes.Router,
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("server listening", "addr", server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	case <-ctx.Done():
+	}
+	stop()
+
+	return shutdown(server, shutdownTracing)
+}
+
+// shutdown reports not-ready, stops accepting connections, waits for
+// in-flight requests and processing jobs, then flushes telemetry and closes
+// the backend clients. Every step shares the SHUTDOWN_TIMEOUT deadline.
+func shutdown(server *http.Server, shutdownTracing func(context.Context) error) error {
+	slog.Info("shutting down", "timeout", configs.EnvConfigs.ShutdownTimeout)
+	routes.SetDraining()
+	time.Sleep(configs.EnvConfigs.ShutdownDrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), configs.EnvConfigs.ShutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	if err := server.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := functions.WaitForJobs(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := routes.CloseClients(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		slog.Info("shutdown complete")
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	StageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "image_pipeline_stage_duration_seconds",
+		Help:    "Duration of image pipeline stages (decode, resize, watermark, encode, upload, download).",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"stage"})
+
+	ImageBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_bytes_total",
+		Help: "Image bytes read from (in) and written to (out) storage, and received in uploads (upload).",
+	}, []string{"direction"})
+
+	ImageDimensions = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "image_dimensions_pixels",
+		Help:    "Width and height of decoded source images.",
+		Buckets: []float64{100, 250, 500, 1000, 1500, 2000, 3000, 4000, 6000, 8000, 10000},
+	}, []string{"axis"})
+
+	Errors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "errors_total",
+		Help: "Errors by type, from requests and background jobs.",
+	}, []string{"type"})
+
+	JobQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "image_job_queue_depth",
+		Help: "Background image jobs waiting for a worker.",
+	})
+
+	BackgroundJobsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_background_jobs_dropped_total",
+		Help: "Background image jobs dropped because the queue was full or the service was shutting down, by job.",
+	}, []string{"job"})
+)
+
+// ObserveStage records the time elapsed since start for a pipeline stage.
+func ObserveStage(stage string, start time.Time) {
+	StageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// ObserveDimensions records the size of a decoded image.
+func ObserveDimensions(width, height int) {
+	ImageDimensions.WithLabelValues("width").Observe(float64(width))
+	ImageDimensions.WithLabelValues("height").Observe(float64(height))
+}
+
+// CountingReader counts bytes read into image_bytes_total{direction="in"}.
+type CountingReader struct {
+	io.Reader
+}
+
+func (r CountingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	ImageBytes.WithLabelValues("in").Add(float64(n))
+	return n, err
+}
+
+// CountingWriter counts bytes written into image_bytes_total{direction="out"}.
+type CountingWriter struct {
+	io.Writer
+}
+
+func (w CountingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	ImageBytes.WithLabelValues("out").Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,265 @@
+// Package pngopt writes PNGs as small as a lossless pure-Go encoder allows.
+// Optimize tries a palette encoding when the image has at most 256 colors, a
+// grayscale encoding when it is opaque and colorless, and a truecolor one,
+// all at best compression, and keeps the smallest. Only the chunks needed to
+// display the image are written, along with the original's color space
+// chunks, so colors look the same once optimized.
+package pngopt
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"slices"
+)
+
+var signature = []byte("\x89PNG\r\n\x1a\n")
+
+// keptChunks are the chunks Strip leaves in place: those holding the image
+// and those describing its color space. Everything else is metadata.
+var keptChunks = map[string]bool{
+	"IHDR": true, "PLTE": true, "tRNS": true, "IDAT": true, "IEND": true,
+	"iCCP": true, "sRGB": true, "gAMA": true, "cHRM": true,
+}
+
+// colorChunks are the kept chunks image/png neither reads nor writes, which
+// Optimize copies from the original into re-encoded candidates.
+var colorChunks = map[string]bool{"iCCP": true, "sRGB": true, "gAMA": true, "cHRM": true}
+
+var encoder = png.Encoder{CompressionLevel: png.BestCompression}
+
+// Optimize returns the smallest lossless PNG encoding of img. original is the
+// file img was decoded from, or nil; when it is a PNG, the file itself with
+// its metadata stripped is one of the candidates, and its color space chunks
+// are added to the others. An ICC profile only describes gray or color
+// pixels, so candidates of the other kind are then left out.
+func Optimize(img image.Image, original []byte) ([]byte, error) {
+	colorSpace, originalGray, _ := colorSpaceChunks(original)
+	_, hasProfile := colorSpace["iCCP"]
+
+	var candidates []image.Image
+	pixels, exact := toNRGBA(img)
+	if exact {
+		if paletted := palettize(pixels); paletted != nil {
+			candidates = append(candidates, paletted)
+		}
+		if gray := grayscale(pixels); gray != nil {
+			candidates = append(candidates, gray)
+		}
+		candidates = append(candidates, pixels)
+	} else {
+		// Deeper than 8 bits per channel: encode as decoded to keep the precision
+		candidates = append(candidates, img)
+	}
+
+	var best []byte
+	for _, candidate := range candidates {
+		var buf bytes.Buffer
+		if err := encoder.Encode(&buf, candidate); err != nil {
+			return nil, err
+		}
+		encoded := buf.Bytes()
+		if hasProfile && isGray(encoded) != originalGray {
+			continue
+		}
+		encoded = insertAfterIHDR(encoded, colorSpace)
+		if best == nil || len(encoded) < len(best) {
+			best = encoded
+		}
+	}
+	if stripped, err := Strip(original); err == nil && (best == nil || len(stripped) < len(best)) {
+		best = stripped
+	}
+	if best == nil {
+		return nil, errors.New("pngopt: no candidate matches the original's color profile")
+	}
+	return best, nil
+}
+
+// colorSpaceChunks returns the color space chunks of a PNG file, keyed by
+// type, and whether its pixels are gray.
+func colorSpaceChunks(data []byte) (chunks map[string][]byte, gray bool, err error) {
+	chunks = map[string][]byte{}
+	err = eachChunk(data, func(kind string, chunk []byte) {
+		if colorChunks[kind] {
+			chunks[kind] = chunk
+		}
+	})
+	return chunks, isGray(data), err
+}
+
+// isGray reports whether the IHDR of a PNG file, which must come first,
+// declares a gray or gray and alpha color type.
+func isGray(data []byte) bool {
+	// Signature, chunk length and type, then width, height and bit depth
+	const colorType = 8 + 8 + 9
+	return len(data) > colorType && (data[colorType] == 0 || data[colorType] == 4)
+}
+
+// insertAfterIHDR returns a PNG file with chunks added after its header, in
+// a fixed order. image/png writes no chunk of its own there.
+func insertAfterIHDR(data []byte, chunks map[string][]byte) []byte {
+	if len(chunks) == 0 {
+		return data
+	}
+	// Signature and an IHDR chunk of 13 data bytes
+	const end = 8 + 12 + 13
+	out := make([]byte, 0, len(data)+256)
+	out = append(out, data[:end]...)
+	for _, kind := range []string{"iCCP", "sRGB", "gAMA", "cHRM"} {
+		out = append(out, chunks[kind]...)
+	}
+	return append(out, data[end:]...)
+}
+
+// Strip removes every chunk of a PNG file except IHDR, PLTE, tRNS, IDAT and
+// IEND and the iCCP, sRGB, gAMA and cHRM color space chunks.
+func Strip(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(signature)
+	err := eachChunk(data, func(kind string, chunk []byte) {
+		if keptChunks[kind] {
+			out.Write(chunk)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// eachChunk calls fn with the type and the whole of every chunk of a PNG
+// file, up to and including IEND.
+func eachChunk(data []byte, fn func(kind string, chunk []byte)) error {
+	if !bytes.HasPrefix(data, signature) {
+		return errors.New("pngopt: not a PNG file")
+	}
+	for rest := data[len(signature):]; ; {
+		if len(rest) < 12 {
+			return errors.New("pngopt: truncated chunk")
+		}
+		length := binary.BigEndian.Uint32(rest)
+		if uint64(length)+12 > uint64(len(rest)) {
+			return errors.New("pngopt: truncated chunk")
+		}
+		chunk := rest[:length+12]
+		kind := string(chunk[4:8])
+		fn(kind, chunk)
+		if kind == "IEND" {
+			return nil
+		}
+		rest = rest[length+12:]
+	}
+}
+
+// toNRGBA copies img to 8-bit non-premultiplied pixels. exact is false when
+// that loses precision, in which case the copy should not be written.
+func toNRGBA(img image.Image) (pixels *image.NRGBA, exact bool) {
+	if nrgba, ok := img.(*image.NRGBA); ok {
+		return nrgba, true
+	}
+	b := img.Bounds()
+	pixels = image.NewNRGBA(b)
+	exact = true
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var c color.NRGBA
+			switch v := img.At(x, y).(type) {
+			case color.NRGBA:
+				c = v
+			case color.NRGBA64:
+				c = color.NRGBA{uint8(v.R >> 8), uint8(v.G >> 8), uint8(v.B >> 8), uint8(v.A >> 8)}
+				exact = exact && isByte(uint32(v.R)) && isByte(uint32(v.G)) && isByte(uint32(v.B)) && isByte(uint32(v.A))
+			default:
+				r, g, b, a := v.RGBA()
+				switch a {
+				case 0:
+				case 0xffff:
+					c = color.NRGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 0xff}
+					exact = exact && isByte(r) && isByte(g) && isByte(b)
+				default:
+					// Premultiplied translucent colors do not round-trip exactly
+					c = color.NRGBAModel.Convert(v).(color.NRGBA)
+					exact = false
+				}
+			}
+			pixels.SetNRGBA(x, y, c)
+		}
+	}
+	return pixels, exact
+}
+
+// isByte reports whether a 16-bit channel value is an 8-bit one widened.
+func isByte(v uint32) bool {
+	return v>>8 == v&0xff
+}
+
+// palettize returns pixels as a paletted image, or nil when it has more than
+// 256 colors. Fully transparent pixels share one entry, and translucent
+// entries come first so the tRNS chunk stays short.
+func palettize(pixels *image.NRGBA) *image.Paletted {
+	index := map[color.NRGBA]int{}
+	b := pixels.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := visible(pixels.NRGBAAt(x, y))
+			if _, ok := index[c]; !ok {
+				if len(index) == 256 {
+					return nil
+				}
+				index[c] = len(index)
+			}
+		}
+	}
+
+	colors := make([]color.NRGBA, 0, len(index))
+	for c := range index {
+		colors = append(colors, c)
+	}
+	slices.SortFunc(colors, func(a, b color.NRGBA) int {
+		return cmp.Or(cmp.Compare(a.A, b.A), cmp.Compare(a.R, b.R), cmp.Compare(a.G, b.G), cmp.Compare(a.B, b.B))
+	})
+	palette := make(color.Palette, len(colors))
+	for i, c := range colors {
+		palette[i] = c
+		index[c] = i
+	}
+
+	paletted := image.NewPaletted(b, palette)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := paletted.Pix[paletted.PixOffset(b.Min.X, y):]
+		for x := b.Min.X; x < b.Max.X; x++ {
+			row[x-b.Min.X] = uint8(index[visible(pixels.NRGBAAt(x, y))])
+		}
+	}
+	return paletted
+}
+
+// visible maps every fully transparent color to the same value.
+func visible(c color.NRGBA) color.NRGBA {
+	if c.A == 0 {
+		return color.NRGBA{}
+	}
+	return c
+}
+
+// grayscale returns pixels as a gray image, or nil when any pixel is
+// translucent or colored.
+func grayscale(pixels *image.NRGBA) *image.Gray {
+	b := pixels.Bounds()
+	gray := image.NewGray(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := pixels.NRGBAAt(x, y)
+			if c.A != 0xff || c.R != c.G || c.G != c.B {
+				return nil
+			}
+			gray.SetGray(x, y, color.Gray{Y: c.R})
+		}
+	}
+	return gray
+}
@@ -0,0 +1,117 @@
+package pngopt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// chunk returns a PNG chunk of the given type and data.
+func chunk(kind string, data []byte) []byte {
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	out = append(out, kind...)
+	out = append(out, data...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(out[4:]))
+}
+
+// withChunks encodes img and adds chunks after its header.
+func withChunks(t *testing.T, img image.Image, chunks ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	const end = 8 + 12 + 13
+	out := append([]byte{}, data[:end]...)
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	return append(out, data[end:]...)
+}
+
+func chunkTypes(t *testing.T, data []byte) map[string]bool {
+	t.Helper()
+	types := map[string]bool{}
+	if err := eachChunk(data, func(kind string, _ []byte) { types[kind] = true }); err != nil {
+		t.Fatal(err)
+	}
+	return types
+}
+
+func TestOptimizeKeepsColorSpace(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 4), uint8(y * 4), 90, 255})
+		}
+	}
+	original := withChunks(t, img,
+		chunk("sRGB", []byte{0}),
+		chunk("gAMA", binary.BigEndian.AppendUint32(nil, 45455)),
+		chunk("tEXt", []byte("Comment\x00made with a long comment nobody needs")),
+	)
+
+	optimized, err := Optimize(img, original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := chunkTypes(t, optimized)
+	for _, kind := range []string{"sRGB", "gAMA"} {
+		if !types[kind] {
+			t.Errorf("%s chunk dropped", kind)
+		}
+	}
+	if types["tEXt"] {
+		t.Error("tEXt chunk kept")
+	}
+	if _, err := png.Decode(bytes.NewReader(optimized)); err != nil {
+		t.Errorf("optimized file does not decode: %v", err)
+	}
+
+	stripped, err := Strip(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if types := chunkTypes(t, stripped); !types["sRGB"] || !types["gAMA"] || types["tEXt"] {
+		t.Errorf("Strip kept %v", types)
+	}
+}
+
+// TestOptimizeProfileColorType checks that an RGB ICC profile never ends up
+// in a gray file, even when a gray encoding would be smaller.
+func TestOptimizeProfileColorType(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8((x*7 + y*13) % 256)
+			img.SetNRGBA(x, y, color.NRGBA{v, v, v, 255})
+		}
+	}
+	// A profile name, compression method and stand-in compressed data
+	original := withChunks(t, img, chunk("iCCP", []byte("RGB profile\x00\x00\x78\x9c\x03\x00\x00\x00\x00\x01")))
+
+	optimized, err := Optimize(img, original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !chunkTypes(t, optimized)["iCCP"] {
+		t.Error("iCCP chunk dropped")
+	}
+	if isGray(optimized) {
+		t.Error("RGB profile written to a gray file")
+	}
+
+	// Without a profile the gray encoding is allowed and smallest
+	plain, err := Optimize(img, withChunks(t, img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isGray(plain) {
+		t.Error("gray image without a profile not written as gray")
+	}
+}
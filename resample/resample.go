@@ -0,0 +1,338 @@
+// Package resample is the service's image resize engine. It resizes in
+// premultiplied RGBA with separable filters, splits rows across CPU cores,
+// reads the source a row at a time instead of converting it whole, reuses its
+// intermediate buffers and halves large downscales with a cheap box filter
+// before the final, higher quality pass.
+package resample
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Filter selects the interpolation kernel.
+type Filter int
+
+const (
+	Nearest Filter = iota
+	Linear
+	CatmullRom
+	Lanczos
+)
+
+var filterNames = map[string]Filter{
+	"nearest":    Nearest,
+	"linear":     Linear,
+	"catmullrom": CatmullRom,
+	"lanczos":    Lanczos,
+}
+
+// ParseFilter returns the filter called name: nearest, linear, catmullrom
+// or lanczos.
+func ParseFilter(name string) (Filter, error) {
+	filter, ok := filterNames[strings.ToLower(strings.ReplaceAll(name, "-", ""))]
+	if !ok {
+		return 0, fmt.Errorf("unknown resize filter %q, expected nearest, linear, catmullrom or lanczos", name)
+	}
+	return filter, nil
+}
+
+func (f Filter) String() string {
+	for name, filter := range filterNames {
+		if filter == f {
+			return name
+		}
+	}
+	return fmt.Sprintf("Filter(%d)", int(f))
+}
+
+// support is the kernel radius in source pixels at scale 1.
+func (f Filter) support() float64 {
+	switch f {
+	case Linear:
+		return 1
+	case CatmullRom:
+		return 2
+	case Lanczos:
+		return 3
+	}
+	return 0.5
+}
+
+func (f Filter) kernel(x float64) float64 {
+	x = math.Abs(x)
+	switch f {
+	case Linear:
+		if x < 1 {
+			return 1 - x
+		}
+	case CatmullRom:
+		if x < 1 {
+			return (1.5*x-2.5)*x*x + 1
+		}
+		if x < 2 {
+			return ((-0.5*x+2.5)*x-4)*x + 2
+		}
+	case Lanczos:
+		if x == 0 {
+			return 1
+		}
+		if x < 3 {
+			return 3 * math.Sin(math.Pi*x) * math.Sin(math.Pi*x/3) / (math.Pi * math.Pi * x * x)
+		}
+	default:
+		if x <= 0.5 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Resize scales img to width x height. A zero width or height is derived
+// from the other to keep the aspect ratio. The result is premultiplied RGBA.
+func Resize(img image.Image, width, height int, filter Filter) *image.RGBA {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if width <= 0 && height <= 0 || srcW == 0 || srcH == 0 {
+		return image.NewRGBA(image.Rect(0, 0, max(width, 0), max(height, 0)))
+	}
+	if width <= 0 {
+		width = max(int(math.Round(float64(srcW)*float64(height)/float64(srcH))), 1)
+	}
+	if height <= 0 {
+		height = max(int(math.Round(float64(srcH)*float64(width)/float64(srcW))), 1)
+	}
+
+	src := img
+	if filter != Nearest && srcW >= 4*width && srcH >= 4*height {
+		// Each halving averages 2x2 blocks; stop while the final pass still
+		// has at least twice the target resolution to filter from
+		halved := halve(img)
+		for halved.Rect.Dx() >= 4*width && halved.Rect.Dy() >= 4*height {
+			halved = halve(halved)
+		}
+		src, srcW, srcH = halved, halved.Rect.Dx(), halved.Rect.Dy()
+	}
+	if srcW == width && srcH == height {
+		return toRGBA(src)
+	}
+
+	tmp := getBuffer(width * srcH * 4)
+	defer putBuffer(tmp)
+	horizontal := newWeights(srcW, width, filter)
+	readRow := rowReader(src)
+	parallel(srcH, func(y0, y1 int) {
+		scratch := make([]uint8, srcW*4)
+		for y := y0; y < y1; y++ {
+			row := readRow(y, scratch)
+			out := tmp[y*width*4 : (y+1)*width*4]
+			for x, w := range horizontal {
+				var r, g, b, a float32
+				for i, weight := range w.weights {
+					p := row[(w.first+i)*4:]
+					r += float32(p[0]) * weight
+					g += float32(p[1]) * weight
+					b += float32(p[2]) * weight
+					a += float32(p[3]) * weight
+				}
+				out[x*4+0], out[x*4+1], out[x*4+2], out[x*4+3] = r, g, b, a
+			}
+		}
+	})
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	vertical := newWeights(srcH, height, filter)
+	parallel(height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			w := vertical[y]
+			out := dst.Pix[y*dst.Stride:]
+			for x := 0; x < width; x++ {
+				var r, g, b, a float32
+				for i, weight := range w.weights {
+					p := tmp[((w.first+i)*width+x)*4:]
+					r += p[0] * weight
+					g += p[1] * weight
+					b += p[2] * weight
+					a += p[3] * weight
+				}
+				alpha := clamp(a)
+				// Ringing must not push a premultiplied channel above alpha
+				out[x*4+0] = min(clamp(r), alpha)
+				out[x*4+1] = min(clamp(g), alpha)
+				out[x*4+2] = min(clamp(b), alpha)
+				out[x*4+3] = alpha
+			}
+		}
+	})
+	return dst
+}
+
+// Fit scales img down to fit within maxWidth x maxHeight, keeping the aspect
+// ratio. Images that already fit are returned unscaled.
+func Fit(img image.Image, maxWidth, maxHeight int, filter Filter) *image.RGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w <= maxWidth && h <= maxHeight {
+		return toRGBA(img)
+	}
+	if float64(w)/float64(h) > float64(maxWidth)/float64(maxHeight) {
+		return Resize(img, maxWidth, 0, filter)
+	}
+	return Resize(img, 0, maxHeight, filter)
+}
+
+// toRGBA returns img as premultiplied RGBA with its origin at 0,0, copying
+// only when needed.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Rect, img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+// rowReader returns a function returning row y of img, counted from the top
+// of its bounds, as premultiplied RGBA. RGBA rows are read in place, other
+// rows are converted into scratch, which holds one row, so the source is
+// never copied whole.
+func rowReader(img image.Image) func(y int, scratch []uint8) []uint8 {
+	bounds := img.Bounds()
+	switch img := img.(type) {
+	case *image.RGBA:
+		return func(y int, _ []uint8) []uint8 {
+			i := img.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			return img.Pix[i : i+bounds.Dx()*4]
+		}
+	case *image.NRGBA:
+		return func(y int, scratch []uint8) []uint8 {
+			i := img.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			row := img.Pix[i : i+bounds.Dx()*4]
+			for j := 0; j < len(row); j += 4 {
+				// The same rounding as draw.Draw
+				a := uint32(row[j+3]) * 0x101
+				scratch[j+0] = uint8(uint32(row[j+0]) * 0x101 * a / 0xffff >> 8)
+				scratch[j+1] = uint8(uint32(row[j+1]) * 0x101 * a / 0xffff >> 8)
+				scratch[j+2] = uint8(uint32(row[j+2]) * 0x101 * a / 0xffff >> 8)
+				scratch[j+3] = row[j+3]
+			}
+			return scratch
+		}
+	}
+	return func(y int, scratch []uint8) []uint8 {
+		row := &image.RGBA{Pix: scratch, Stride: len(scratch), Rect: image.Rect(0, 0, bounds.Dx(), 1)}
+		draw.Draw(row, row.Rect, img, image.Pt(bounds.Min.X, bounds.Min.Y+y), draw.Src)
+		return scratch
+	}
+}
+
+// halve averages every 2x2 block. An odd last row or column is dropped.
+func halve(src image.Image) *image.RGBA {
+	w, h := src.Bounds().Dx()/2, src.Bounds().Dy()/2
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	readRow := rowReader(src)
+	parallel(h, func(y0, y1 int) {
+		topScratch, bottomScratch := make([]uint8, src.Bounds().Dx()*4), make([]uint8, src.Bounds().Dx()*4)
+		for y := y0; y < y1; y++ {
+			top := readRow(2*y, topScratch)
+			bottom := readRow(2*y+1, bottomScratch)
+			out := dst.Pix[y*dst.Stride:]
+			for x := 0; x < w*4; x++ {
+				i := x/4*8 + x%4
+				out[x] = uint8((uint32(top[i]) + uint32(top[i+4]) + uint32(bottom[i]) + uint32(bottom[i+4]) + 2) / 4)
+			}
+		}
+	})
+	return dst
+}
+
+// contribution lists the source pixels, starting at first, that make up one
+// output pixel.
+type contribution struct {
+	first   int
+	weights []float32
+}
+
+func newWeights(srcLen, dstLen int, filter Filter) []contribution {
+	scale := float64(srcLen) / float64(dstLen)
+	// Downscaling widens the kernel so every source pixel contributes
+	stretch := math.Max(scale, 1)
+	support := filter.support() * stretch
+	contributions := make([]contribution, dstLen)
+	for i := range contributions {
+		center := (float64(i)+0.5)*scale - 0.5
+		if filter == Nearest {
+			first := min(max(int(math.Round(center)), 0), srcLen-1)
+			contributions[i] = contribution{first: first, weights: []float32{1}}
+			continue
+		}
+		first := max(int(math.Ceil(center-support)), 0)
+		last := min(int(math.Floor(center+support)), srcLen-1)
+		weights := make([]float32, 0, last-first+1)
+		var sum float64
+		for j := first; j <= last; j++ {
+			weight := filter.kernel((float64(j) - center) / stretch)
+			weights = append(weights, float32(weight))
+			sum += weight
+		}
+		if sum != 0 {
+			for j := range weights {
+				weights[j] = float32(float64(weights[j]) / sum)
+			}
+		}
+		contributions[i] = contribution{first: first, weights: weights}
+	}
+	return contributions
+}
+
+// minRowsPerWorker keeps goroutine overhead small compared to the work.
+const minRowsPerWorker = 16
+
+// parallel calls work on disjoint row ranges covering [0, rows), using up to
+// GOMAXPROCS goroutines.
+func parallel(rows int, work func(y0, y1 int)) {
+	workers := min(runtime.GOMAXPROCS(0), rows/minRowsPerWorker)
+	if workers <= 1 {
+		work(0, rows)
+		return
+	}
+	var wg sync.WaitGroup
+	chunk := (rows + workers - 1) / workers
+	for y := 0; y < rows; y += chunk {
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			work(y0, y1)
+		}(y, min(y+chunk, rows))
+	}
+	wg.Wait()
+}
+
+var buffers sync.Pool
+
+// getBuffer returns a float32 slice of length n from the pool. Its contents
+// are not zeroed; callers overwrite every element.
+func getBuffer(n int) []float32 {
+	if buf, ok := buffers.Get().(*[]float32); ok && cap(*buf) >= n {
+		return (*buf)[:n]
+	}
+	return make([]float32, n)
+}
+
+func putBuffer(buf []float32) {
+	buffers.Put(&buf)
+}
+
+func clamp(v float32) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
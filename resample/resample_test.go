@@ -0,0 +1,272 @@
+package resample
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestResizeDimensions(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	tests := []struct {
+		width, height int
+		wantW, wantH  int
+	}{
+		{200, 150, 200, 150},
+		{200, 0, 200, 150},
+		{0, 60, 80, 60},
+		{90, 90, 90, 90},
+		{800, 0, 800, 600},
+		{1, 0, 1, 1},
+		{400, 300, 400, 300},
+	}
+	for _, filter := range []Filter{Nearest, Linear, CatmullRom, Lanczos} {
+		for _, tt := range tests {
+			got := Resize(src, tt.width, tt.height, filter).Bounds()
+			if got != image.Rect(0, 0, tt.wantW, tt.wantH) {
+				t.Errorf("%v %dx%d: got %v, want %dx%d at 0,0", filter, tt.width, tt.height, got, tt.wantW, tt.wantH)
+			}
+		}
+	}
+}
+
+func TestFitDimensions(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	tests := []struct {
+		maxWidth, maxHeight int
+		want                image.Rectangle
+	}{
+		{200, 200, image.Rect(0, 0, 200, 150)},
+		{400, 150, image.Rect(0, 0, 200, 150)},
+		{1000, 1000, image.Rect(0, 0, 400, 300)},
+	}
+	for _, tt := range tests {
+		if got := Fit(src, tt.maxWidth, tt.maxHeight, Lanczos).Bounds(); got != tt.want {
+			t.Errorf("Fit(%d, %d): got %v, want %v", tt.maxWidth, tt.maxHeight, got, tt.want)
+		}
+	}
+}
+
+// TestResizeSubImage checks that a sub-image is read from its own bounds,
+// not from the origin of the underlying pixels.
+func TestResizeSubImage(t *testing.T) {
+	red, blue := color.NRGBA{R: 255, A: 255}, color.NRGBA{B: 255, A: 255}
+	img := image.NewNRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			if x < 100 {
+				img.SetNRGBA(x, y, red)
+			} else {
+				img.SetNRGBA(x, y, blue)
+			}
+		}
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, image.Point{}, draw.Src)
+	gray := image.NewGray(img.Bounds())
+	draw.Draw(gray, gray.Bounds(), img, image.Point{}, draw.Src)
+	wantGray := gray.Pix[gray.PixOffset(150, 50)]
+
+	r := image.Rect(100, 20, 200, 80)
+	sources := map[string]struct {
+		img  image.Image
+		want color.RGBA
+	}{
+		"NRGBA": {img.SubImage(r), color.RGBA{B: 255, A: 255}},
+		"RGBA":  {rgba.SubImage(r), color.RGBA{B: 255, A: 255}},
+		"Gray":  {gray.SubImage(r), color.RGBA{wantGray, wantGray, wantGray, 255}},
+	}
+	for name, source := range sources {
+		for _, filter := range []Filter{Nearest, Linear, Lanczos} {
+			// 20 pixels wide takes the halving path
+			for _, width := range []int{50, 20} {
+				got := Resize(source.img, width, 0, filter)
+				if want := image.Rect(0, 0, width, width*3/5); got.Bounds() != want {
+					t.Fatalf("%s %v to %d: got bounds %v, want %v", name, filter, width, got.Bounds(), want)
+				}
+				for i := 0; i < len(got.Pix); i += 4 {
+					if c := (color.RGBA{got.Pix[i], got.Pix[i+1], got.Pix[i+2], got.Pix[i+3]}); c != source.want {
+						t.Fatalf("%s %v to %d: pixel %d is %v, want the sub-image's %v", name, filter, width, i/4, c, source.want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestHalve(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 5, 3))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i)
+	}
+	got := halve(src)
+	// The odd last row and column are dropped
+	if got.Bounds() != image.Rect(0, 0, 2, 1) {
+		t.Fatalf("got bounds %v, want 2x1", got.Bounds())
+	}
+	for x := 0; x < 2; x++ {
+		for c := 0; c < 4; c++ {
+			sum := 0
+			for _, p := range []image.Point{{2 * x, 0}, {2*x + 1, 0}, {2 * x, 1}, {2*x + 1, 1}} {
+				sum += int(src.Pix[src.PixOffset(p.X, p.Y)+c])
+			}
+			if want := uint8((sum + 2) / 4); got.Pix[x*4+c] != want {
+				t.Errorf("pixel %d channel %d: got %d, want %d", x, c, got.Pix[x*4+c], want)
+			}
+		}
+	}
+}
+
+// TestResizeHalvingPath resizes a one pixel checkerboard far enough down to
+// take the halving path, which must average it to mid gray rather than alias.
+func TestResizeHalvingPath(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 1600, 1200))
+	for y := 0; y < 1200; y++ {
+		for x := 0; x < 1600; x++ {
+			if (x+y)%2 == 0 {
+				src.Pix[y*src.Stride+x] = 255
+			}
+		}
+	}
+	got := Resize(src, 100, 0, Lanczos)
+	if got.Bounds() != image.Rect(0, 0, 100, 75) {
+		t.Fatalf("got bounds %v, want 100x75", got.Bounds())
+	}
+	for i := 0; i < len(got.Pix); i += 4 {
+		if v := got.Pix[i]; v < 126 || v > 129 || got.Pix[i+3] != 255 {
+			t.Fatalf("pixel %d is %v, want opaque mid gray", i/4, got.Pix[i:i+4])
+		}
+	}
+}
+
+// TestResizePremultipliedAlpha resizes hard edges between opaque white and
+// transparent pixels; no color channel of the premultiplied result may
+// exceed its alpha, even where Lanczos rings.
+func TestResizePremultipliedAlpha(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 120, 90))
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 120; x++ {
+			if (x/7+y/5)%2 == 0 {
+				src.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+			} else {
+				// Transparent pixels keep a color premultiplication must hide
+				src.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 0})
+			}
+		}
+	}
+	for _, filter := range []Filter{Linear, CatmullRom, Lanczos} {
+		for _, width := range []int{37, 60, 250} {
+			got := Resize(src, width, 0, filter)
+			for i := 0; i < len(got.Pix); i += 4 {
+				r, g, b, a := got.Pix[i], got.Pix[i+1], got.Pix[i+2], got.Pix[i+3]
+				if r > a || g > a || b > a {
+					t.Fatalf("%v to %d: pixel %d is %v, channels above alpha", filter, width, i/4, got.Pix[i:i+4])
+				}
+				// Red only exists in transparent pixels and must not bleed
+				if int(r)-int(g) > 1 {
+					t.Fatalf("%v to %d: pixel %d is %v, transparent color bled in", filter, width, i/4, got.Pix[i:i+4])
+				}
+			}
+		}
+	}
+}
+
+// On one CPU, resizes that skip the halving path run at imaging's speed and
+// differ only in memory; downscales of 4x or more are faster because they
+// are halved with a box filter before the final pass.
+func benchmarkSource() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 4000, 3000))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	return img
+}
+
+func BenchmarkResizeLanczos(b *testing.B) {
+	src := benchmarkSource()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Resize(src, 1500, 0, Lanczos)
+	}
+}
+
+func BenchmarkImagingResizeLanczos(b *testing.B) {
+	src := benchmarkSource()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		imaging.Resize(src, 1500, 0, imaging.Lanczos)
+	}
+}
+
+func BenchmarkResizeLinear(b *testing.B) {
+	src := benchmarkSource()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Resize(src, 1500, 0, Linear)
+	}
+}
+
+func BenchmarkImagingResizeLinear(b *testing.B) {
+	src := benchmarkSource()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		imaging.Resize(src, 1500, 0, imaging.Linear)
+	}
+}
+
+// BenchmarkResizeThumbnail takes the halving path.
+func BenchmarkResizeThumbnail(b *testing.B) {
+	src := benchmarkSource()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Resize(src, 300, 0, Lanczos)
+	}
+}
+
+func BenchmarkImagingResizeThumbnail(b *testing.B) {
+	src := benchmarkSource()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		imaging.Resize(src, 300, 0, imaging.Lanczos)
+	}
+}
+
+// benchmarkYCbCr is a decoded JPEG sized like a camera original.
+func benchmarkYCbCr() *image.YCbCr {
+	img := image.NewYCbCr(image.Rect(0, 0, 4000, 3000), image.YCbCrSubsampleRatio420)
+	for i := range img.Y {
+		img.Y[i] = uint8(i * 7)
+	}
+	for i := range img.Cb {
+		img.Cb[i], img.Cr[i] = uint8(i*3), uint8(i*5)
+	}
+	return img
+}
+
+// BenchmarkResizeYCbCr scales a decoded JPEG to a 500px variant.
+func BenchmarkResizeYCbCr(b *testing.B) {
+	src := benchmarkYCbCr()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Resize(src, 500, 0, Lanczos)
+	}
+}
+
+func BenchmarkImagingResizeYCbCr(b *testing.B) {
+	src := benchmarkYCbCr()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		imaging.Resize(src, 500, 0, imaging.Lanczos)
+	}
+}
@@ -0,0 +1,35 @@
+package routes
+
+import (
+	"Project/configs"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireAdmin only lets requests through that carry SECRET_KEY as a bearer
+// token. Admin routes are disabled when no secret key is configured.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret := configs.EnvConfigs.SecretKey
+		if secret == "" {
+			abortWithProblem(c, http.StatusForbidden, "Admin endpoints are disabled")
+			return
+		}
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			c.Header("WWW-Authenticate", "Bearer")
+			abortWithProblem(c, http.StatusUnauthorized, "Missing or invalid admin token")
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetConfig handles GET /v1/admin/config and returns the effective
+// configuration with secrets redacted.
+func GetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, configs.EnvConfigs.Redacted())
+}
@@ -0,0 +1,119 @@
+package routes
+
+import (
+	"Project/configs"
+	"context"
+	"fmt"
+	"os"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/api/option"
+)
+
+// Credential sources, selected with CREDENTIALS_SOURCE.
+const (
+	CredentialsAuto    = "auto"    // first configured of file, json and secret, else default
+	CredentialsFile    = "file"    // GOOGLE_CRED key file path
+	CredentialsJSON    = "json"    // GOOGLE_CRED_JSON inline service account JSON
+	CredentialsSecret  = "secret"  // GOOGLE_CRED_SECRET Secret Manager version name
+	CredentialsDefault = "default" // application default credentials
+)
+
+// SecretProvider fetches secret payloads. SecretManagerProvider is used in
+// production; tests can substitute a fake.
+type SecretProvider interface {
+	AccessSecret(ctx context.Context, name string) ([]byte, error)
+}
+
+// SecretManagerProvider reads secrets from Google Secret Manager using
+// application default credentials.
+type SecretManagerProvider struct{}
+
+func (SecretManagerProvider) AccessSecret(ctx context.Context, name string) ([]byte, error) {
+	return FetchCredentialsFromSecretManager(ctx, name)
+}
+
+// CredentialSettings selects where Google client credentials come from.
+type CredentialSettings struct {
+	Source     string
+	File       string
+	JSON       string
+	SecretName string
+}
+
+func CredentialSettingsFromConfig() CredentialSettings {
+	return CredentialSettings{
+		Source:     configs.EnvConfigs.CredentialsSource,
+		File:       configs.EnvConfigs.GoogleCred,
+		JSON:       configs.EnvConfigs.GoogleCredJSON,
+		SecretName: configs.EnvConfigs.GoogleCredSecret,
+	}
+}
+
+// CredentialOptions resolves settings to client options and reports which
+// source was used. An empty option list means application default credentials.
+func CredentialOptions(ctx context.Context, settings CredentialSettings, secrets SecretProvider) ([]option.ClientOption, string, error) {
+	source := settings.Source
+	if source == "" || source == CredentialsAuto {
+		switch {
+		case settings.File != "":
+			source = CredentialsFile
+		case settings.JSON != "":
+			source = CredentialsJSON
+		case settings.SecretName != "":
+			source = CredentialsSecret
+		default:
+			source = CredentialsDefault
+		}
+	}
+
+	switch source {
+	case CredentialsFile:
+		if _, err := os.Stat(settings.File); err != nil {
+			return nil, source, fmt.Errorf("credentials file %q is not readable: %v", settings.File, err)
+		}
+		return []option.ClientOption{option.WithCredentialsFile(settings.File)}, source, nil
+	case CredentialsJSON:
+		if settings.JSON == "" {
+			return nil, source, fmt.Errorf("GOOGLE_CRED_JSON is empty")
+		}
+		return []option.ClientOption{option.WithCredentialsJSON([]byte(settings.JSON))}, source, nil
+	case CredentialsSecret:
+		if settings.SecretName == "" {
+			return nil, source, fmt.Errorf("GOOGLE_CRED_SECRET is empty")
+		}
+		data, err := secrets.AccessSecret(ctx, settings.SecretName)
+		if err != nil {
+			return nil, source, fmt.Errorf("failed to load credentials from secret %s: %v", settings.SecretName, err)
+		}
+		return []option.ClientOption{option.WithCredentialsJSON(data)}, source, nil
+	case CredentialsDefault:
+		return nil, source, nil
+	default:
+		return nil, source, fmt.Errorf("unknown credentials source %q", source)
+	}
+}
+
+func FetchCredentialsFromSecretManager(ctx context.Context, secretName string) ([]byte, error) {
+	// Create the Secret Manager client
+	client, err := secretmanager.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secret manager client: %v", err)
+	}
+	defer client.Close()
+
+	// Build the request
+	req := &secretmanagerpb.AccessSecretVersionRequest{
+		Name: secretName,
+	}
+
+	// Access the secret version
+	result, err := client.AccessSecretVersion(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access secret version: %v", err)
+	}
+
+	// Return the secret payload (credentials JSON)
+	return result.Payload.Data, nil
+}
@@ -0,0 +1,129 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSecrets returns a fixed payload or error and records what was asked for.
+type fakeSecrets struct {
+	data      []byte
+	err       error
+	requested []string
+}
+
+func (f *fakeSecrets) AccessSecret(ctx context.Context, name string) ([]byte, error) {
+	f.requested = append(f.requested, name)
+	return f.data, f.err
+}
+
+func TestCredentialOptions(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(keyFile, []byte(`{"type": "service_account"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	missingFile := filepath.Join(t.TempDir(), "missing.json")
+	const secretName = "projects/p/secrets/creds/versions/latest"
+
+	tests := []struct {
+		name        string
+		settings    CredentialSettings
+		secrets     *fakeSecrets
+		wantSource  string
+		wantOptions int
+		wantErr     string
+		wantSecret  bool
+	}{
+		{
+			name:        "auto prefers the file",
+			settings:    CredentialSettings{Source: CredentialsAuto, File: keyFile, JSON: `{}`, SecretName: secretName},
+			wantSource:  CredentialsFile,
+			wantOptions: 1,
+		},
+		{
+			name:        "auto falls back to inline JSON",
+			settings:    CredentialSettings{JSON: `{}`, SecretName: secretName},
+			wantSource:  CredentialsJSON,
+			wantOptions: 1,
+		},
+		{
+			name:        "auto falls back to the secret",
+			settings:    CredentialSettings{Source: CredentialsAuto, SecretName: secretName},
+			secrets:     &fakeSecrets{data: []byte(`{}`)},
+			wantSource:  CredentialsSecret,
+			wantOptions: 1,
+			wantSecret:  true,
+		},
+		{
+			name:       "auto uses default credentials when nothing is set",
+			settings:   CredentialSettings{Source: CredentialsAuto},
+			wantSource: CredentialsDefault,
+		},
+		{
+			name:       "missing file",
+			settings:   CredentialSettings{Source: CredentialsFile, File: missingFile},
+			wantSource: CredentialsFile,
+			wantErr:    "is not readable",
+		},
+		{
+			name:       "empty JSON",
+			settings:   CredentialSettings{Source: CredentialsJSON},
+			wantSource: CredentialsJSON,
+			wantErr:    "GOOGLE_CRED_JSON is empty",
+		},
+		{
+			name:        "secret provider returns data",
+			settings:    CredentialSettings{Source: CredentialsSecret, SecretName: secretName},
+			secrets:     &fakeSecrets{data: []byte(`{"type": "service_account"}`)},
+			wantSource:  CredentialsSecret,
+			wantOptions: 1,
+			wantSecret:  true,
+		},
+		{
+			name:       "secret provider fails",
+			settings:   CredentialSettings{Source: CredentialsSecret, SecretName: secretName},
+			secrets:    &fakeSecrets{err: errors.New("permission denied")},
+			wantSource: CredentialsSecret,
+			wantErr:    "permission denied",
+			wantSecret: true,
+		},
+		{
+			name:       "unknown source",
+			settings:   CredentialSettings{Source: "vault"},
+			wantSource: "vault",
+			wantErr:    "unknown credentials source",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secrets := tt.secrets
+			if secrets == nil {
+				secrets = &fakeSecrets{err: errors.New("secret provider should not be called")}
+			}
+			options, source, err := CredentialOptions(context.Background(), tt.settings, secrets)
+			if source != tt.wantSource {
+				t.Errorf("source = %q, want %q", source, tt.wantSource)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(options) != tt.wantOptions {
+				t.Errorf("got %d client options, want %d", len(options), tt.wantOptions)
+			}
+			if called := len(secrets.requested) > 0; called != tt.wantSecret {
+				t.Errorf("secret provider called = %v, want %v", called, tt.wantSecret)
+			}
+			if tt.wantSecret && secrets.requested[0] != secretName {
+				t.Errorf("requested secret %q, want %q", secrets.requested[0], secretName)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package routes
+
+import (
+	"Project/configs"
+	"Project/functions"
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var draining atomic.Bool
+
+// SetDraining makes /readyz report 503 so load balancers stop routing new
+// requests while the server shuts down.
+func SetDraining() {
+	draining.Store(true)
+}
+
+// dependencyStatus is public, so the cause of a failure is only logged.
+type dependencyStatus struct {
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latencyMs"`
+}
+
+// Liveness handles GET /healthz and only reports that the process is serving.
+func Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readiness handles GET /readyz, checking every backend concurrently.
+func Readiness(c *gin.Context) {
+	if draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), configs.EnvConfigs.ReadinessTimeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) error{
+		"storage": func(ctx context.Context) error {
+			return functions.CheckStorage(ctx, StorageClient)
+		},
+		"firestore": func(ctx context.Context) error {
+			return functions.CheckFirestore(ctx, FirestoreClient)
+		},
+	}
+
+	var (
+		mu           sync.Mutex
+		wg           sync.WaitGroup
+		dependencies = make(map[string]dependencyStatus, len(checks))
+		ready        = true
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := check(ctx)
+			result := dependencyStatus{Status: "ok", LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = "unavailable"
+				slog.WarnContext(ctx, "readiness check failed", "dependency", name, "error", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			dependencies[name] = result
+			if err != nil {
+				ready = false
+			}
+		}()
+	}
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{"status": status, "dependencies": dependencies})
+}
@@ -0,0 +1,233 @@
+package routes
+
+import (
+	"Project/functions"
+	"Project/logging"
+	"Project/metrics"
+	"Project/tracing"
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	tenantHeader    = "X-Tenant-ID"
+)
+
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"requestID,omitempty"`
+}
+
+// RequestID reuses the caller's X-Request-ID or generates a new one, echoes
+// it back on the response and stores it, with the X-Tenant-ID header, in the
+// request context so every log line carries them.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if id == "" {
+			id = uuid.NewString()
+		}
+		c.Set("requestID", id)
+		c.Header(requestIDHeader, id)
+		ctx := logging.WithRequestID(c.Request.Context(), id)
+		if tenant := c.GetHeader(tenantHeader); tenant != "" {
+			ctx = logging.WithTenant(ctx, tenant)
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// RequestLogger writes one structured log line per request.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(c.Request.Context(), level, "request completed",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+			"duration", time.Since(start),
+		)
+	}
+}
+
+// ErrorHandler renders the last error attached with c.Error as problem+json,
+// unless the handler already wrote a response.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		err := c.Errors.Last().Err
+		metrics.Errors.WithLabelValues(functions.ErrorType(err)).Inc()
+		status, detail := problemForError(err)
+		if status >= http.StatusInternalServerError {
+			slog.ErrorContext(c.Request.Context(), "request failed", "error", err)
+		}
+		writeProblem(c, status, detail)
+	}
+}
+
+// Recovery turns a panic in a later handler into a logged 500 problem+json
+// response. It runs inside the logging, metrics and tracing middleware so
+// they record the 500 too.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if r == http.ErrAbortHandler {
+				panic(r)
+			}
+			metrics.Errors.WithLabelValues("internal").Inc()
+			slog.ErrorContext(c.Request.Context(), "handler panicked", "panic", r, "stack", string(debug.Stack()))
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			abortWithProblem(c, http.StatusInternalServerError, "Internal server error")
+		}()
+		c.Next()
+	}
+}
+
+// Tracing continues the caller's W3C trace context, or starts a new trace,
+// and makes the server span available through c.Request.Context().
+func Tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracing.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("request.id", c.GetString("requestID")),
+			),
+		)
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// Metrics records request counts and latency per route pattern.
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPRequests.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		metrics.HTTPDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// BodyLimit caps the number of bytes a handler can read from the request body.
+func BodyLimit(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if maxBytes > 0 && c.Request.Body != nil {
+			if c.Request.ContentLength > maxBytes {
+				abortWithProblem(c, http.StatusRequestEntityTooLarge, "Request body too large")
+				return
+			}
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		}
+		c.Next()
+	}
+}
+
+func writeProblem(c *gin.Context, status int, detail string) {
+	c.Header("Content-Type", "application/problem+json")
+	c.JSON(status, Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  c.Request.URL.Path,
+		RequestID: c.GetString("requestID"),
+	})
+}
+
+func abortWithProblem(c *gin.Context, status int, detail string) {
+	writeProblem(c, status, detail)
+	c.Abort()
+}
+
+// abortWithBindError reports 413 when binding failed because the body limit
+// was hit, and 400 otherwise.
+func abortWithBindError(c *gin.Context, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		abortWithProblem(c, http.StatusRequestEntityTooLarge, "Request body too large")
+		return
+	}
+	abortWithProblem(c, http.StatusBadRequest, "Invalid request body")
+}
+
+// problemForError maps functions error kinds to an HTTP status and a detail
+// that is safe to return. Unclassified errors never leak their message.
+func problemForError(err error) (int, string) {
+	var status int
+	switch {
+	case errors.Is(err, functions.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, functions.ErrInvalidInput):
+		status = http.StatusBadRequest
+	case errors.Is(err, functions.ErrUnsupportedFormat):
+		status = http.StatusUnsupportedMediaType
+	case errors.Is(err, functions.ErrImageTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, functions.ErrUpstream):
+		status = http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	var domainErr *functions.Error
+	if errors.As(err, &domainErr) {
+		return status, domainErr.Detail
+	}
+	return status, http.StatusText(status)
+}
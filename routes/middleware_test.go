@@ -0,0 +1,89 @@
+package routes
+
+import (
+	"Project/functions"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestErrorHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantDetail string
+	}{
+		{"too large", &functions.Error{Kind: functions.ErrImageTooLarge, Detail: "width 20000 exceeds 10000"}, http.StatusRequestEntityTooLarge, "width 20000 exceeds 10000"},
+		{"unsupported format", &functions.Error{Kind: functions.ErrUnsupportedFormat, Detail: "image format image/svg+xml is not allowed"}, http.StatusUnsupportedMediaType, "image format image/svg+xml is not allowed"},
+		{"corrupt image", &functions.Error{Kind: functions.ErrInvalidInput, Detail: "unable to decode image, the file is corrupt"}, http.StatusBadRequest, "unable to decode image, the file is corrupt"},
+		{"not found", &functions.Error{Kind: functions.ErrNotFound, Detail: "image not found"}, http.StatusNotFound, "image not found"},
+		{"upstream", &functions.Error{Kind: functions.ErrUpstream, Detail: "storage unavailable", Err: errors.New("dial tcp: refused")}, http.StatusBadGateway, "storage unavailable"},
+		{"wrapped", fmt.Errorf("upload: %w", &functions.Error{Kind: functions.ErrImageTooLarge, Detail: "payload exceeds 10 bytes"}), http.StatusRequestEntityTooLarge, "payload exceeds 10 bytes"},
+		{"unclassified", errors.New("secret internal detail"), http.StatusInternalServerError, "Internal server error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(ErrorHandler())
+			router.GET("/", func(c *gin.Context) { c.Error(tt.err) })
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("Content-Type %q, want application/problem+json", ct)
+			}
+			var problem Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+				t.Fatalf("body %q: %v", w.Body, err)
+			}
+			if problem.Status != tt.wantStatus || problem.Detail != tt.wantDetail {
+				t.Errorf("problem %+v, want status %d and detail %q", problem, tt.wantStatus, tt.wantDetail)
+			}
+		})
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	router := gin.New()
+	router.Use(RequestID(), Metrics(), ErrorHandler(), Recovery())
+	router.GET("/panic", func(c *gin.Context) { panic("boom") })
+	router.GET("/panic-after-write", func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		panic("boom")
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+	req.Header.Set(requestIDHeader, "req-1")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Content-Type %q, want application/problem+json", ct)
+	}
+	var problem Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("body %q: %v", w.Body, err)
+	}
+	want := Problem{Type: "about:blank", Title: "Internal Server Error", Status: 500, Detail: "Internal server error", Instance: "/panic", RequestID: "req-1"}
+	if problem != want {
+		t.Errorf("problem %+v, want %+v", problem, want)
+	}
+
+	// A started response cannot be replaced, only cut short
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic-after-write", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "partial" {
+		t.Errorf("got %d %q, want the partial response left as written", w.Code, w.Body)
+	}
+}
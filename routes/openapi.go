@@ -0,0 +1,302 @@
+package routes
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+// openAPISpec is the hand-maintained contract for every route. Keep it in
+// sync when adding or changing handlers; ValidateRequest enforces it.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+type openAPISchema struct {
+	Ref        string                    `json:"$ref"`
+	Type       string                    `json:"type"`
+	Enum       []string                  `json:"enum"`
+	Pattern    string                    `json:"pattern"`
+	Required   []string                  `json:"required"`
+	Properties map[string]*openAPISchema `json:"properties"`
+	Items      *openAPISchema            `json:"items"`
+
+	pattern *regexp.Regexp
+}
+
+type openAPIParameter struct {
+	Ref      string         `json:"$ref"`
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPIOperation struct {
+	Parameters  []*openAPIParameter `json:"parameters"`
+	RequestBody *struct {
+		Required bool `json:"required"`
+		Content  map[string]struct {
+			Schema *openAPISchema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+}
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components struct {
+		Parameters map[string]*openAPIParameter `json:"parameters"`
+		Schemas    map[string]*openAPISchema    `json:"schemas"`
+	} `json:"components"`
+}
+
+var openAPI = mustLoadOpenAPI(openAPISpec)
+
+func mustLoadOpenAPI(spec []byte) *openAPIDocument {
+	doc := &openAPIDocument{}
+	if err := json.Unmarshal(spec, doc); err != nil {
+		panic(fmt.Sprintf("invalid openapi.json: %v", err))
+	}
+	if err := doc.resolve(); err != nil {
+		panic(fmt.Sprintf("invalid openapi.json: %v", err))
+	}
+	return doc
+}
+
+// resolve replaces local $refs with the referenced components and compiles patterns.
+func (doc *openAPIDocument) resolve() error {
+	for _, schema := range doc.Components.Schemas {
+		if err := doc.resolveSchema(schema); err != nil {
+			return err
+		}
+	}
+	for _, methods := range doc.Paths {
+		for _, op := range methods {
+			for i, param := range op.Parameters {
+				if param.Ref != "" {
+					resolved, ok := doc.Components.Parameters[strings.TrimPrefix(param.Ref, "#/components/parameters/")]
+					if !ok {
+						return fmt.Errorf("unknown parameter %s", param.Ref)
+					}
+					op.Parameters[i] = resolved
+					param = resolved
+				}
+				if err := doc.resolveSchema(param.Schema); err != nil {
+					return err
+				}
+			}
+			if op.RequestBody == nil {
+				continue
+			}
+			for mediaType, content := range op.RequestBody.Content {
+				schema, err := doc.lookupSchema(content.Schema)
+				if err != nil {
+					return err
+				}
+				content.Schema = schema
+				op.RequestBody.Content[mediaType] = content
+			}
+		}
+	}
+	return nil
+}
+
+func (doc *openAPIDocument) lookupSchema(schema *openAPISchema) (*openAPISchema, error) {
+	if schema == nil || schema.Ref == "" {
+		return schema, doc.resolveSchema(schema)
+	}
+	resolved, ok := doc.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema %s", schema.Ref)
+	}
+	return resolved, nil
+}
+
+func (doc *openAPIDocument) resolveSchema(schema *openAPISchema) error {
+	if schema == nil || schema.Ref != "" {
+		return nil
+	}
+	if schema.Pattern != "" && schema.pattern == nil {
+		re, err := regexp.Compile(schema.Pattern)
+		if err != nil {
+			return err
+		}
+		schema.pattern = re
+	}
+	for name, property := range schema.Properties {
+		resolved, err := doc.lookupSchema(property)
+		if err != nil {
+			return err
+		}
+		schema.Properties[name] = resolved
+	}
+	if schema.Items != nil {
+		resolved, err := doc.lookupSchema(schema.Items)
+		if err != nil {
+			return err
+		}
+		schema.Items = resolved
+	}
+	return nil
+}
+
+// operation finds the operation for a Gin route pattern such as /v1/health/:size.
+func (doc *openAPIDocument) operation(method, fullPath string) *openAPIOperation {
+	return doc.Paths[openAPIPath(fullPath)][strings.ToLower(method)]
+}
+
+// openAPIPath turns a Gin route pattern into an OpenAPI path template:
+// /v1/health/:size becomes /v1/health/{size}, and catch-all *name segments
+// become {name}.
+func openAPIPath(fullPath string) string {
+	segments := strings.Split(fullPath, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// ValidateRequest rejects requests whose path parameters or JSON body do not
+// match the OpenAPI document. Routes absent from the document are not checked.
+func ValidateRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op := openAPI.operation(c.Request.Method, c.FullPath())
+		if op == nil {
+			c.Next()
+			return
+		}
+		for _, param := range op.Parameters {
+			if param.In != "path" {
+				continue
+			}
+			if err := validateValue(param.Schema, c.Param(param.Name), param.Name); err != nil {
+				abortWithProblem(c, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if op.RequestBody != nil {
+			content, ok := op.RequestBody.Content["application/json"]
+			if !ok {
+				c.Next()
+				return
+			}
+			body, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				abortWithBindError(c, err)
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			var value interface{}
+			if err := json.Unmarshal(body, &value); err != nil {
+				abortWithProblem(c, http.StatusBadRequest, "Request body is not valid JSON")
+				return
+			}
+			if err := validateValue(content.Schema, value, "body"); err != nil {
+				abortWithProblem(c, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+func validateValue(schema *openAPISchema, value interface{}, field string) error {
+	if schema == nil {
+		return nil
+	}
+	switch schema.Type {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string", field)
+		}
+		if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, s) {
+			return fmt.Errorf("%s must be one of %s", field, strings.Join(schema.Enum, ", "))
+		}
+		if schema.pattern != nil && !schema.pattern.MatchString(s) {
+			return fmt.Errorf("%s must match %s", field, schema.Pattern)
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok || (schema.Type == "integer" && n != math.Trunc(n)) {
+			return fmt.Errorf("%s must be an %s", field, schema.Type)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s must be a boolean", field)
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an array", field)
+		}
+		for i, item := range items {
+			if err := validateValue(schema.Items, item, fmt.Sprintf("%s[%d]", field, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an object", field)
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s.%s is required", field, name)
+			}
+		}
+		for name, property := range schema.Properties {
+			if v, ok := object[name]; ok {
+				if err := validateValue(property, v, field+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// GetOpenAPI serves the OpenAPI document.
+func GetOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openAPISpec)
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>Image Handling Service API</title>
+    <link rel="stylesheet" href="/v1/docs/assets/swagger-ui.css">
+</head>
+<body>
+    <div id="swagger-ui"></div>
+    <script src="/v1/docs/assets/swagger-ui-bundle.js"></script>
+    <script>
+        window.ui = SwaggerUIBundle({ url: '/v1/openapi.json', dom_id: '#swagger-ui' });
+    </script>
+</body>
+</html>`
+
+// GetAPIDocs serves a Swagger UI page for the OpenAPI document.
+func GetAPIDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+}
+
+// GetAPIDocAsset serves the Swagger UI files embedded in the binary, so the
+// docs page loads nothing from third-party hosts and its version is pinned
+// by go.sum.
+func GetAPIDocAsset(c *gin.Context) {
+	c.FileFromFS(strings.TrimPrefix(c.Param("filepath"), "/"), http.FS(swaggerFiles.FS))
+}
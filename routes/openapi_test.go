@@ -0,0 +1,89 @@
+package routes
+
+import (
+	"Project/configs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	configs.InitiEnvConfigs()
+	InitializeRoutes()
+	os.Exit(m.Run())
+}
+
+// TestRoutesMatchOpenAPI fails when a handler is registered without being
+// documented, or the document describes a route that does not exist.
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	registered := map[string]bool{}
+	for _, route := range Router.Routes() {
+		if openAPI.operation(route.Method, route.Path) == nil {
+			t.Errorf("%s %s is not in openapi.json", route.Method, route.Path)
+		}
+		registered[strings.ToLower(route.Method)+" "+openAPIPath(route.Path)] = true
+	}
+	for path, methods := range openAPI.Paths {
+		for method := range methods {
+			if !registered[method+" "+path] {
+				t.Errorf("openapi.json describes %s %s, which has no handler", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	router := gin.New()
+	router.Use(ValidateRequest())
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	router.POST("/v2/watermarks", ok)
+	router.POST("/v1/uploadWatermark", ok)
+	router.GET("/v2/images/:id", ok)
+	router.POST("/v2/images/:id/variants", ok)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"valid body", http.MethodPost, "/v2/watermarks", `{"base64image": "AAAA", "name": "logo"}`, http.StatusNoContent},
+		{"missing required property", http.MethodPost, "/v2/watermarks", `{"name": "logo"}`, http.StatusBadRequest},
+		{"pattern mismatch", http.MethodPost, "/v2/watermarks", `{"base64image": "AAAA", "name": "../posts/x"}`, http.StatusBadRequest},
+		{"v1 keeps free-form names", http.MethodPost, "/v1/uploadWatermark", `{"base64image": "AAAA", "imagename": "logos/brand.final.png"}`, http.StatusNoContent},
+		{"wrong type", http.MethodPost, "/v2/watermarks", `{"base64image": 1, "name": "logo"}`, http.StatusBadRequest},
+		{"invalid JSON", http.MethodPost, "/v2/watermarks", `{`, http.StatusBadRequest},
+		{"valid path parameter", http.MethodGet, "/v2/images/image_1", "", http.StatusNoContent},
+		{"invalid path parameter", http.MethodGet, "/v2/images/image.1", "", http.StatusBadRequest},
+		{"array item type", http.MethodPost, "/v2/images/image_1/variants", `{"variants": [1]}`, http.StatusBadRequest},
+		{"valid array", http.MethodPost, "/v2/images/image_1/variants", `{"variants": ["small"]}`, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusBadRequest && !strings.HasPrefix(w.Header().Get("Content-Type"), "application/problem+json") {
+				t.Errorf("got content type %q, want a problem document", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestAPIDocAssetsAreEmbedded(t *testing.T) {
+	for _, asset := range []string{"/v1/docs/assets/swagger-ui.css", "/v1/docs/assets/swagger-ui-bundle.js"} {
+		w := httptest.NewRecorder()
+		Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, asset, nil))
+		if w.Code != http.StatusOK || w.Body.Len() == 0 {
+			t.Errorf("GET %s: status %d, %d bytes", asset, w.Code, w.Body.Len())
+		}
+	}
+}
@@ -4,85 +4,107 @@ import (
 	"Project/configs"
 	"Project/functions"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
-	"time"
+	"path"
 
 	"cloud.google.com/go/firestore"
-	secretmanager "cloud.google.com/go/secretmanager/apiv1"
-	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"cloud.google.com/go/storage"
 	"github.com/gin-gonic/gin"
-	"google.golang.org/api/option"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 var (
-	Router          = gin.Default()
+	Router          = gin.New()
 	StorageClient   *storage.Client
 	FirestoreClient *firestore.Client
-	latestStatus    string
+
+	// storageHTTPClient propagates trace context to Firebase Storage downloads
+	storageHTTPClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
 )
 
 func InitializeRoutes() {
+	storageHTTPClient.Timeout = configs.EnvConfigs.StorageTimeout
+	Router.Use(RequestID(), Tracing(), RequestLogger(), Metrics(), ErrorHandler(), Recovery(), BodyLimit(configs.EnvConfigs.MaxRequestBytes), ValidateRequest())
+	Router.GET("healthz", Liveness)
+	Router.GET("readyz", Readiness)
+	Router.GET("metrics", gin.WrapH(promhttp.Handler()))
+
 	publicRoutes := Router.Group("v1/")
 	publicRoutes.GET("health", HealthCheck)
+	publicRoutes.GET("openapi.json", GetOpenAPI)
+	publicRoutes.GET("docs", GetAPIDocs)
+	publicRoutes.GET("docs/assets/*filepath", GetAPIDocAsset)
+	publicRoutes.GET("admin/config", RequireAdmin(), GetConfig)
 	publicRoutes.GET("health/:id/:size", GetImagePath)
 	publicRoutes.GET("health/:id/:size/water", GetWaterImagePath)
 	publicRoutes.POST("uploadWatermark", PostWatermarkImage)
 	publicRoutes.POST("health", PostImage)
 	publicRoutes.POST("health/:size", PostImageResize)
 	publicRoutes.POST("health/:size/water", PostImageWatermark)
-
+	publicRoutes.PATCH("images/:id", PatchImage)
+
+	v2Routes := Router.Group("v2/")
+	v2Routes.POST("images", CreateImage)
+	v2Routes.GET("images/:id", GetImage)
+	v2Routes.POST("images/:id/variants", CreateImageVariants)
+	v2Routes.PUT("images/:id/variants/:variant", PutImageVariant)
+	v2Routes.GET("images/:id/variants/:variant", GetImageVariant)
+	v2Routes.POST("images/:id/crops", CreateCrop)
+	v2Routes.POST("images/:id/filters", CreateFiltered)
+	v2Routes.GET("images/:id/derivatives/:derivative", GetImageDerivative)
+	v2Routes.POST("watermarks", CreateWatermark)
+	v2Routes.GET("formats", GetFormats)
 }
 
 func InitializeClients() error {
 
 	ctx := context.Background()
 
+	credsOption, source, err := CredentialOptions(ctx, CredentialSettingsFromConfig(), SecretManagerProvider{})
+	if err != nil {
+		return err
+	}
+	slog.Info("using Google credentials", "source", source)
+
 	// Initialize Storage client
-	var err error
-	credsOption := option.WithCredentialsFile(configs.EnvConfigs.GoogleCred)
-	StorageClient, err = storage.NewClient(ctx, credsOption)
+	StorageClient, err = storage.NewClient(ctx, credsOption...)
 	if err != nil {
 		return fmt.Errorf("failed to initialize storage client: %v", err)
 	}
 
 	// Initialize Firestore client
-	FirestoreClient, err = firestore.NewClient(ctx, "halogen-device-438608-v9", credsOption)
+	FirestoreClient, err = firestore.NewClient(ctx, configs.EnvConfigs.ProjectID, credsOption...)
 	if err != nil {
 		return fmt.Errorf("failed to initialize Firestore client: %v", err)
 	}
 
 	return nil
 }
-func FetchCredentialsFromSecretManager(secretName string) ([]byte, error) {
-	// Create the Secret Manager client
-	ctx := context.Background()
-	client, err := secretmanager.NewClient(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create secret manager client: %v", err)
-	}
-	defer client.Close()
 
-	// Build the request
-	req := &secretmanagerpb.AccessSecretVersionRequest{
-		Name: secretName,
+// CloseClients releases the storage and Firestore clients.
+func CloseClients() error {
+	var errs []error
+	if StorageClient != nil {
+		if err := StorageClient.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close storage client: %v", err))
+		}
 	}
-
-	// Access the secret version
-	result, err := client.AccessSecretVersion(ctx, req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to access secret version: %v", err)
+	if FirestoreClient != nil {
+		if err := FirestoreClient.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close Firestore client: %v", err))
+		}
 	}
-
-	// Return the secret payload (credentials JSON)
-	return result.Payload.Data, nil
+	return errors.Join(errs...)
 }
+
 func HealthCheck(context *gin.Context) {
-	latestStatus = "API is working fine !!!!"
+	latestStatus := "API is working fine !!!!"
 	context.JSON(http.StatusOK, gin.H{
 		"message": latestStatus,
 	})
@@ -90,34 +112,39 @@ func HealthCheck(context *gin.Context) {
 
 func PostImage(c *gin.Context) {
 	var requestBody struct {
-		Base64Image string `json:"base64image"`
+		Base64Image string   `json:"base64image"`
+		Variants    []string `json:"variants"` // generated in the background, AUTO_VARIANTS when omitted
 	}
 
-	if err := c.BindJSON(&requestBody); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		fmt.Println("Invalid Request Body")
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+	pending, err := functions.PlanAutoVariants(requestBody.Variants)
+	if err != nil {
+		c.Error(err)
 		return
 	}
-	location, err := time.LoadLocation("Asia/Kuala_Lumpur")
+	imageID, timestamp, err := functions.NewImageID()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load timezone"})
-		fmt.Println("Failed to load timezone")
+		c.Error(err)
 		return
 	}
-	currentTime := time.Now().In(location)
-	timestamp := currentTime.Format("20060102_150405")
-	imageID := fmt.Sprintf("image_%s", timestamp)
 	// Call the function to upload the image
-	err = functions.UploadImageHandler(requestBody.Base64Image, StorageClient, FirestoreClient, timestamp)
+	err = functions.UploadImageHandler(c.Request.Context(), requestBody.Base64Image, StorageClient, FirestoreClient, timestamp)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
-	latestStatus = fmt.Sprintf("image_%v uploaded successfully", imageID)
-	log.Printf("Image uploaded with ID: %s", imageID)
+	if !functions.GenerateVariantsInBackground(c.Request.Context(), imageID, pending, StorageClient, FirestoreClient) {
+		pending = []string{}
+	}
+	latestStatus := fmt.Sprintf("image_%v uploaded successfully", imageID)
+	slog.InfoContext(c.Request.Context(), "image uploaded", "image_id", imageID, "pending_variants", pending)
 	c.JSON(http.StatusOK, gin.H{
-		"status":  latestStatus,
-		"imageID": imageID,
+		"status":          latestStatus,
+		"imageID":         imageID,
+		"pendingVariants": pending,
 	})
 }
 
@@ -125,15 +152,14 @@ func PostImageResize(c *gin.Context) {
 	var requestBody struct {
 		ImageID string `json:"imageID"` // Expecting the Image ID to be sent in the POST request
 	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		abortWithBindError(c, err)
 		return
 	}
 	sizename := c.Param("size")
-	err := functions.ProcessResizeImage(requestBody.ImageID, sizename, StorageClient, FirestoreClient)
+	err := functions.ProcessResizeImage(c.Request.Context(), requestBody.ImageID, sizename, StorageClient, FirestoreClient)
 	if err != nil {
-		log.Printf("Error in ProcessResizeImage: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	latestStatus := fmt.Sprintf("%v resized to %v successfully", requestBody.ImageID, sizename)
@@ -146,131 +172,162 @@ func PostImageWatermark(c *gin.Context) {
 	var requestBody struct {
 		ImageID string `json:"imageID"` // Expecting the Image ID to be sent in the POST request
 	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		abortWithBindError(c, err)
 		return
 	}
 	sizename := c.Param("size")
-	err := functions.ProcessImageWithWatermark(requestBody.ImageID, sizename, StorageClient, FirestoreClient)
-
+	result, err := functions.WatermarkVariant(c.Request.Context(), requestBody.ImageID, sizename, StorageClient, FirestoreClient)
 	if err != nil {
-		// c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to Process"})
-		errResize := functions.ProcessResizeImage(requestBody.ImageID, sizename, StorageClient, FirestoreClient)
-		if errResize != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to process resize"})
-			return
-		}
-		errWatermark := functions.ProcessImageWithWatermark(requestBody.ImageID, sizename, StorageClient, FirestoreClient)
-		if errWatermark != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to process watermark"})
-			return
-		}
-		latestStatus := fmt.Sprintf("%v resized to %v and watermarked successfully after watermarking failed", requestBody.ImageID, sizename)
-		c.JSON(http.StatusOK, gin.H{
-			"status": latestStatus,
-		})
+		c.Error(err)
 		return
 	}
-	latestStatus = fmt.Sprintf("%s_watermarked_%s.jpg saved successfully", sizename, requestBody.ImageID)
+	latestStatus := fmt.Sprintf("%s saved successfully", path.Base(result.Path))
+	if result.CreatedResize {
+		latestStatus = fmt.Sprintf("%v resized to %v and watermarked successfully", requestBody.ImageID, sizename)
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"status": latestStatus,
+		"result": result,
 	})
 }
 
 func GetImagePath(c *gin.Context) {
 	ImageID := c.Param("id")
 	sizename := c.Param("size")
-	imageDetails, err := functions.GetImageDetailsFromFireStore(FirestoreClient, ImageID, sizename)
+	imageDetails, err := functions.GetImageDetailsFromFireStore(c.Request.Context(), FirestoreClient, ImageID, sizename)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 		return
 	}
 
-	// Extract the image path from Firestore document
-	imagePath, ok := imageDetails["Path"].(string)
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Image path not found in Firestore document",
-		})
+	serveVariant(c, ImageID, sizename, imageDetails)
+}
+func GetWaterImagePath(c *gin.Context) {
+	ImageID := c.Param("id")
+	sizename := c.Param("size")
+
+	// Retrieve the image details from Firestore
+	imageDetails, err := functions.GetWaterImageDetailFromFirestore(c.Request.Context(), FirestoreClient, ImageID, sizename)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	// Construct the Firebase Storage download URL (adjust the base URL as necessary)
-	baseStorageURL := "https://firebasestorage.googleapis.com/v0/b/halogen-device-438608-v9.appspot.com/o/"
-	fullURL := fmt.Sprintf("%s%s?alt=media", baseStorageURL, url.PathEscape(imagePath))
+	serveVariant(c, ImageID, functions.VariantName(sizename, true), imageDetails)
+}
 
-	// Fetch the image from Firebase Storage using the URL
-	resp, err := http.Get(fullURL)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to download image from Firebase Storage",
-		})
+func PostWatermarkImage(c *gin.Context) {
+	var requestBody struct {
+		Base64Image string `json:"base64image"`
+		ImageName   string `json:"imagename"` // Expect the name to be provided in the request body
+	}
+
+	// Bind the JSON request to the requestBody struct
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		abortWithBindError(c, err)
 		return
 	}
-	defer resp.Body.Close()
 
-	// Read the image data
-	imageData, err := io.ReadAll(resp.Body)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to read image data",
-		})
+	// Validate that the image name is provided
+	if requestBody.ImageName == "" {
+		abortWithProblem(c, http.StatusBadRequest, "Image name is required")
 		return
 	}
 
-	// Set the content type to match the image type
-	contentType := resp.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = "application/octet-stream" // Fallback if content type is not available
+	// Call the function to upload the watermark image
+	result, err := functions.UploadWatermarkImageHandler(c.Request.Context(), requestBody.Base64Image, requestBody.ImageName, StorageClient, FirestoreClient)
+	if err != nil {
+		c.Error(err)
+		return
 	}
 
-	// Send the image as the response
-	c.Data(http.StatusOK, contentType, imageData)
+	latestStatus := fmt.Sprintf("Watermark image %v uploaded successfully", requestBody.ImageName)
+	slog.InfoContext(c.Request.Context(), "watermark image uploaded", "image_id", requestBody.ImageName)
+
+	// Send success response with the provided image name
+	c.JSON(http.StatusOK, gin.H{
+		"status":    latestStatus,
+		"imageName": requestBody.ImageName,
+		"result":    result,
+	})
 }
-func GetWaterImagePath(c *gin.Context) {
-	ImageID := c.Param("id")
-	sizename := c.Param("size")
 
-	// Retrieve the image details from Firestore
-	imageDetails, err := functions.GetWaterImageDetailFromFirestore(FirestoreClient, ImageID, sizename)
+// PatchImage updates image metadata. Setting the focal point regenerates the
+// crops positioned relative to it.
+func PatchImage(c *gin.Context) {
+	var requestBody struct {
+		FocalPoint *functions.FocalPoint `json:"focalPoint" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+	imageID := c.Param("id")
+	regenerated, err := functions.SetFocalPoint(c.Request.Context(), imageID, *requestBody.FocalPoint, StorageClient, FirestoreClient)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"id": imageID, "focalPoint": requestBody.FocalPoint, "regenerated": regenerated})
+}
+
+// serveVariant serves a variant as the WebP or AVIF rendition the client
+// accepts, encoding it on first request, or as the stored JPEG when the
+// client accepts neither or the rendition cannot be produced.
+func serveVariant(c *gin.Context, imageID, variant string, imageDetails map[string]interface{}) {
+	c.Header("Vary", "Accept")
+	if format := functions.NegotiateFormat(c.GetHeader("Accept")); format != "" {
+		// Serve the JPEG until the rendition is stored
+		renditionPath, attempted := functions.RenditionPath(imageDetails, format)
+		if renditionPath != "" {
+			imageDetails = map[string]interface{}{"Path": renditionPath}
+		} else if !attempted {
+			functions.GenerateRenditionInBackground(c.Request.Context(), imageID, variant, imageDetails, format, StorageClient, FirestoreClient)
+		}
+	}
+	serveStoredImage(c, imageDetails)
+}
 
+// serveStoredImage streams the image referenced by a Firestore document's
+// Path field from Firebase Storage.
+func serveStoredImage(c *gin.Context, imageDetails map[string]interface{}) {
 	// Extract the image path from Firestore document
 	imagePath, ok := imageDetails["Path"].(string)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Image path not found in Firestore document",
-		})
+		abortWithProblem(c, http.StatusInternalServerError, "Image path not found in Firestore document")
 		return
 	}
 
 	// Construct the Firebase Storage download URL (adjust the base URL as necessary)
-	baseStorageURL := "https://firebasestorage.googleapis.com/v0/b/halogen-device-438608-v9.appspot.com/o/"
+	baseStorageURL := "https://firebasestorage.googleapis.com/v0/b/" + url.PathEscape(configs.EnvConfigs.BucketName) + "/o/"
 	fullURL := fmt.Sprintf("%s%s?alt=media", baseStorageURL, url.PathEscape(imagePath))
 
 	// Fetch the image from Firebase Storage using the URL
-	resp, err := http.Get(fullURL)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to download image from Firebase Storage",
-		})
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, fullURL, nil)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	resp, err := storageHTTPClient.Do(req)
+	if err != nil {
+		abortWithProblem(c, http.StatusBadGateway, "Failed to download image from Firebase Storage")
 		return
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		abortWithProblem(c, http.StatusNotFound, "Image not found in Firebase Storage")
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		abortWithProblem(c, http.StatusBadGateway, "Failed to download image from Firebase Storage")
+		return
+	}
 
 	// Read the image data
 	imageData, err := io.ReadAll(resp.Body)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to read image data",
-		})
+		abortWithProblem(c, http.StatusBadGateway, "Failed to read image data")
 		return
 	}
 
@@ -283,39 +340,3 @@ func GetWaterImagePath(c *gin.Context) {
 	// Send the image as the response
 	c.Data(http.StatusOK, contentType, imageData)
 }
-func PostWatermarkImage(c *gin.Context) {
-	var requestBody struct {
-		Base64Image string `json:"base64image"`
-		ImageName   string `json:"imagename"` // Expect the name to be provided in the request body
-	}
-
-	// Bind the JSON request to the requestBody struct
-	if err := c.BindJSON(&requestBody); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		fmt.Println("Invalid Request Body")
-		return
-	}
-
-	// Validate that the image name is provided
-	if requestBody.ImageName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Image name is required"})
-		fmt.Println("Image name not provided")
-		return
-	}
-
-	// Call the function to upload the watermark image
-	err := functions.UploadWatermarkImageHandler(requestBody.Base64Image, requestBody.ImageName, StorageClient, FirestoreClient)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	latestStatus := fmt.Sprintf("Watermark image %v uploaded successfully", requestBody.ImageName)
-	log.Printf("Watermark image uploaded with name: %s", requestBody.ImageName)
-
-	// Send success response with the provided image name
-	c.JSON(http.StatusOK, gin.H{
-		"status":    latestStatus,
-		"imageName": requestBody.ImageName,
-	})
-}
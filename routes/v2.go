@@ -0,0 +1,166 @@
+package routes
+
+import (
+	"Project/functions"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateImage handles POST /v2/images. The variants listed in the request,
+// or AUTO_VARIANTS, are generated in the background after the upload.
+func CreateImage(c *gin.Context) {
+	var requestBody struct {
+		Base64Image string   `json:"base64image" binding:"required"`
+		Variants    []string `json:"variants"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+	pending, err := functions.PlanAutoVariants(requestBody.Variants)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	imageID, timestamp, err := functions.NewImageID()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if err := functions.UploadImageHandler(c.Request.Context(), requestBody.Base64Image, StorageClient, FirestoreClient, timestamp); err != nil {
+		c.Error(err)
+		return
+	}
+	if !functions.GenerateVariantsInBackground(c.Request.Context(), imageID, pending, StorageClient, FirestoreClient) {
+		pending = []string{}
+	}
+	slog.InfoContext(c.Request.Context(), "image uploaded", "image_id", imageID, "pending_variants", pending)
+	c.Header("Location", "/v2/images/"+imageID)
+	c.JSON(http.StatusCreated, gin.H{"id": imageID, "pendingVariants": pending})
+}
+
+// GetImage handles GET /v2/images/:id and returns the image metadata.
+func GetImage(c *gin.Context) {
+	summary, err := functions.GetImageSummary(c.Request.Context(), FirestoreClient, c.Param("id"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+// PutImageVariant handles PUT /v2/images/:id/variants/:variant, creating or
+// regenerating a variant such as "medium" or "watermarked_medium".
+func PutImageVariant(c *gin.Context) {
+	imageID := c.Param("id")
+	variant := c.Param("variant")
+	result, err := functions.CreateVariant(c.Request.Context(), imageID, variant, StorageClient, FirestoreClient)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// CreateImageVariants handles POST /v2/images/:id/variants, producing every
+// listed variant from a single decode of the original.
+func CreateImageVariants(c *gin.Context) {
+	var requestBody struct {
+		Variants []string `json:"variants" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+	variants, err := functions.CreateVariants(c.Request.Context(), c.Param("id"), requestBody.Variants, StorageClient, FirestoreClient)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "variants": variants})
+}
+
+// GetImageVariant handles GET /v2/images/:id/variants/:variant and returns
+// the variant's image bytes.
+func GetImageVariant(c *gin.Context) {
+	details, err := functions.GetVariantDetailsFromFirestore(c.Request.Context(), FirestoreClient, c.Param("id"), c.Param("variant"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	serveVariant(c, c.Param("id"), c.Param("variant"), details)
+}
+
+// CreateCrop handles POST /v2/images/:id/crops, storing a cropped copy of
+// the original image as a derivative.
+func CreateCrop(c *gin.Context) {
+	var spec functions.CropSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+	derivative, err := functions.CropImage(c.Request.Context(), c.Param("id"), spec, StorageClient, FirestoreClient)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.Header("Location", "/v2/images/"+c.Param("id")+"/derivatives/"+derivative.ID)
+	c.JSON(http.StatusCreated, derivative)
+}
+
+// CreateFiltered handles POST /v2/images/:id/filters, applying an ordered
+// list of adjustments to the original image and storing the result as a
+// derivative.
+func CreateFiltered(c *gin.Context) {
+	var requestBody struct {
+		Operations []functions.FilterOp `json:"operations" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+	derivative, err := functions.FilterImage(c.Request.Context(), c.Param("id"), requestBody.Operations, StorageClient, FirestoreClient)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.Header("Location", "/v2/images/"+c.Param("id")+"/derivatives/"+derivative.ID)
+	c.JSON(http.StatusCreated, derivative)
+}
+
+// GetImageDerivative handles GET /v2/images/:id/derivatives/:derivative and
+// returns the derivative's image bytes.
+func GetImageDerivative(c *gin.Context) {
+	details, err := functions.GetDerivativeDetailsFromFirestore(c.Request.Context(), FirestoreClient, c.Param("id"), c.Param("derivative"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	serveStoredImage(c, details)
+}
+
+// CreateWatermark handles POST /v2/watermarks.
+func CreateWatermark(c *gin.Context) {
+	var requestBody struct {
+		Base64Image string `json:"base64image" binding:"required"`
+		Name        string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+	result, err := functions.UploadWatermark(c.Request.Context(), requestBody.Base64Image, requestBody.Name, StorageClient, FirestoreClient)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "watermark image uploaded", "image_id", requestBody.Name)
+	c.JSON(http.StatusCreated, gin.H{"name": requestBody.Name, "result": result})
+}
+
+// GetFormats handles GET /v2/formats and lists the image formats uploads may use.
+func GetFormats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"formats": functions.AcceptedFormats()})
+}
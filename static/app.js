@@ -75,7 +75,7 @@ document.getElementById('resizeButton').addEventListener('click', async function
 
         
     } else {
-        alert(`Error resizing the image: ${result.error || 'Unknown error'}`);
+        alert(`Error resizing the image: ${result.detail || 'Unknown error'}`);
         console.error(result); // Log the entire response for debugging
     }
 });
@@ -158,7 +158,7 @@ document.getElementById('applyWatermarkButton').addEventListener('click', async
     if (response.ok) {
         alert(result.status); // Show success message
     } else {
-        alert(`Error applying watermark: ${result.error || 'Unknown error'}`);
+        alert(`Error applying watermark: ${result.detail || 'Unknown error'}`);
         console.error(result); // Log the entire response for debugging
     }
 });